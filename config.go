@@ -2,11 +2,15 @@ package main
 
 import (
 	"flag"
+	"fmt"
+	"io"
 	"os"
+	"sort"
 	"strconv"
 	"strings"
 	"time"
 
+	"github.com/qcasey/MDroid-Core-Public/format"
 	"github.com/qcasey/MDroid-Core-Public/sessions"
 	"github.com/qcasey/MDroid-Core-Public/sessions/gps"
 	"github.com/qcasey/MDroid-Core-Public/settings"
@@ -28,23 +32,166 @@ func init() {
 	log.Logger = zerolog.New(output).With().Caller().Timestamp().Logger()
 }
 
+// powerTarget is how a device's power is controlled
+var powerTarget = settings.Schema{Default: "AUTO", Allowed: []string{"AUTO", "ON", "OFF"}}
+
+// settingsSchema declares known settings, so they're validated when set and have defaults when missing
+var settingsSchema = map[string]map[string]settings.Schema{
+	"MDROID": {
+		"AUTOLOCK":                  powerTarget,
+		"SLEEP":                     powerTarget,
+		"DEBUG":                     {Type: settings.TypeBool, Default: "FALSE"},
+		"SETTINGS_BACKUPS":          {Type: settings.TypeInt, Default: "3"},
+		"SETTINGS_HISTORY_SIZE":     {Type: settings.TypeInt, Default: "200"},
+		"SESSION_SAVE_INTERVAL":     {Type: settings.TypeInt},
+		"THROUGHPUT_WARN_THRESHOLD": {Type: settings.TypeInt},
+		"SERIAL_STARTUP":            {Type: settings.TypeBool},
+		"CHECKSUM_ALGORITHM":        {Allowed: []string{"CRC32", "HMAC", "NONE"}},
+		"CHECKSUM_KEY":              {CaseSensitive: true, Secret: true},
+		"AUTH_TOKEN":                {CaseSensitive: true, Secret: true},
+		"MDROID_SERVER":             {CaseSensitive: true},
+		"SLACK_URL":                 {CaseSensitive: true, Secret: true},
+		"TIMEZONE":                  {CaseSensitive: true},
+		"SESSION_FILE":              {CaseSensitive: true},
+		"SESSION_SCHEMA_FILE":       {CaseSensitive: true},
+		"HARDWARE_SERIAL_PORT":      {CaseSensitive: true},
+		"PYBUS_DEVICE":              {CaseSensitive: true},
+		"DATABASE_HOST":             {CaseSensitive: true},
+		"DATABASE_NAME":             {CaseSensitive: true},
+		"MQTT_ADDRESS":              {CaseSensitive: true},
+		"MQTT_CLIENT_ID":            {CaseSensitive: true},
+		"MQTT_USERNAME":             {CaseSensitive: true},
+		"MQTT_PASSWORD":             {CaseSensitive: true, Secret: true},
+	},
+	"ANGEL_EYES": {"POWER": powerTarget},
+	"BOARD":      {"POWER": powerTarget},
+	"TABLET":     {"POWER": powerTarget},
+	"WIRELESS":   {"POWER": powerTarget},
+}
+
+// registerSettingsSchema declares the known settings
+func registerSettingsSchema() {
+	for componentName, schema := range settingsSchema {
+		if err := settings.RegisterSchema(componentName, schema); err != nil {
+			log.Error().Msg(err.Error())
+		}
+	}
+}
+
+// configPrefix starts environment variables overriding MDROID settings, i.e. MDROID_TIMEZONE=America/Detroit
+const configPrefix = "MDROID_"
+
+// configFlags collects repeated --config KEY=VALUE flags
+type configFlags []string
+
+func (c *configFlags) String() string {
+	return strings.Join(*c, ",")
+}
+
+func (c *configFlags) Set(value string) error {
+	if !strings.Contains(value, "=") {
+		return fmt.Errorf("expected KEY=VALUE")
+	}
+	*c = append(*c, value)
+	return nil
+}
+
+// overrideConfig holds MDROID settings from the environment, then from --config flags.
+// From lowest to highest precedence, config comes from:
+//  1. Declared defaults
+//  2. The MDROID component of the settings file
+//  3. The active settings profile
+//  4. MDROID_* environment variables
+//  5. --config KEY=VALUE flags, the last one given for a key wins
+//
+// Returns where each overridden setting came from
+func overrideConfig(environment []string, flags []string) map[string]string {
+	origins := make(map[string]string)
+	apply := func(pair string, origin string) {
+		parts := strings.SplitN(pair, "=", 2)
+		if len(parts) != 2 || parts[0] == "" {
+			log.Error().Msgf("Ignoring config override %s from %s, expected KEY=VALUE", pair, origin)
+			return
+		}
+		key := format.Name(parts[0])
+		if err := settings.Override("MDROID", key, parts[1]); err != nil {
+			log.Error().Msgf("Ignoring config override from %s: %s", origin, err.Error())
+			return
+		}
+		origins[key] = origin
+	}
+
+	for _, variable := range environment {
+		if strings.HasPrefix(variable, configPrefix) {
+			apply(strings.TrimPrefix(variable, configPrefix), "environment")
+		}
+	}
+	for _, pair := range flags {
+		apply(pair, "--config")
+	}
+	return origins
+}
+
+// printConfig writes the effective config, sorted, with secrets redacted and overrides marked
+func printConfig(w io.Writer, configMap map[string]string, origins map[string]string) {
+	keys := make([]string, 0, len(configMap))
+	for key := range configMap {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		value := configMap[key]
+		if value != "" && settings.IsSecret("MDROID", key) {
+			value = "<redacted>"
+		}
+		if origin, ok := origins[key]; ok {
+			fmt.Fprintf(w, "%s=%s\t(%s)\n", key, value, origin)
+			continue
+		}
+		fmt.Fprintf(w, "%s=%s\n", key, value)
+	}
+}
+
 // Main config parsing
 func parseConfig() *map[string]string {
 	log.Info().Msg("Starting MDroid Core")
 
+	var overrides configFlags
 	flag.StringVar(&settings.Settings.File, "settings-file", "", "File to recover the persistent settings.")
 	debug := flag.Bool("debug", false, "sets log level to debug")
+	flag.Var(&overrides, "config", "Override a MDROID setting as KEY=VALUE, over MDROID_KEY environment variables. Can be repeated")
+	printOnly := flag.Bool("print-config", false, "Print the effective MDROID config, with secrets redacted, and exit")
 	flag.Parse()
 
 	if *debug {
 		zerolog.SetGlobalLevel(zerolog.DebugLevel)
 	}
 
+	// Declare known settings before they're read, so defaults are applied
+	registerSettingsSchema()
+
+	// Hold settings from the environment and command line over the settings file
+	origins := overrideConfig(os.Environ(), overrides)
+
 	// Parse settings file
 	settings.ReadFile(settings.Settings.File)
+	configMap, err := settings.GetComponent("MDROID")
+
+	// Print the config before giving up on a missing MDROID component
+	if *printOnly {
+		printConfig(os.Stdout, configMap, origins)
+		os.Exit(0)
+	}
+
+	// Pick up any edits made to the settings file while running
+	if settings.Settings.File != "" {
+		if err := settings.Watch(); err != nil {
+			log.Error().Msgf("Could not watch settings file: %s", err.Error())
+		}
+	}
 
 	// Parse through config if found in settings file
-	configMap, err := settings.GetComponent("MDROID")
 	if err != nil {
 		log.Warn().Msg("MDROID settings not found, aborting config")
 		return &configMap // abort config
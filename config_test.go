@@ -0,0 +1,48 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/qcasey/MDroid-Core-Public/settings"
+)
+
+func TestOverrideConfig(t *testing.T) {
+	registerSettingsSchema()
+	environment := []string{"HOME=/root", "MDROID_TIMEZONE=America/Detroit", "MDROID_DEBUG=true", "MDROID_MQTT_PASSWORD=hunter2"}
+	flags := []string{"timezone=UTC", "DEBUG=maybe", "SESSION_SAVE_INTERVAL=30", "SESSION_SAVE_INTERVAL=60"}
+	origins := overrideConfig(environment, flags)
+
+	tables := []struct {
+		key    string
+		value  string
+		origin string
+	}{
+		{"TIMEZONE", "UTC", "--config"},
+		{"DEBUG", "TRUE", "environment"},
+		{"SESSION_SAVE_INTERVAL", "60", "--config"},
+		{"MQTT_PASSWORD", "hunter2", "environment"},
+	}
+
+	for _, table := range tables {
+		if value, err := settings.Get("MDROID", table.key); err != nil || value != table.value {
+			t.Errorf("MDROID[%s] = %s, %v; want %s", table.key, value, err, table.value)
+		}
+		if origins[table.key] != table.origin {
+			t.Errorf("MDROID[%s] came from %s; want %s", table.key, origins[table.key], table.origin)
+		}
+	}
+	if _, ok := origins["HOME"]; ok {
+		t.Errorf("HOME was taken as an override without the %s prefix", configPrefix)
+	}
+	if err := settings.Set("MDROID", "TIMEZONE", "Europe/London"); err == nil {
+		t.Errorf("Set of an overridden setting should have failed")
+	}
+
+	var output bytes.Buffer
+	printConfig(&output, map[string]string{"MQTT_PASSWORD": "hunter2", "TIMEZONE": "UTC"}, origins)
+	want := "MQTT_PASSWORD=<redacted>\t(environment)\nTIMEZONE=UTC\t(--config)\n"
+	if output.String() != want {
+		t.Errorf("printConfig wrote %q; want %q", output.String(), want)
+	}
+}
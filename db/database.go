@@ -3,6 +3,7 @@ package db
 import (
 	"database/sql"
 	"fmt"
+	"sort"
 	"strings"
 	"sync"
 
@@ -33,6 +34,9 @@ type Database struct {
 // DB currently being used
 var DB *Database
 
+// fieldEscaper escapes characters that would end a quoted string field in Influx line protocol
+var fieldEscaper = strings.NewReplacer("\\", "\\\\", "\"", "\\\"")
+
 // Helper function to parse interfaces as a DB string
 func parseWriterData(stmt *strings.Builder, data *map[string]interface{}) error {
 	counter := 0
@@ -47,7 +51,7 @@ func parseWriterData(stmt *strings.Builder, data *map[string]interface{}) error
 		case bool:
 			stmt.WriteString(fmt.Sprintf("%s=%v", key, vv))
 		case string:
-			stmt.WriteString(fmt.Sprintf("%s=\"%v\"", key, vv))
+			stmt.WriteString(fmt.Sprintf("%s=\"%s\"", key, fieldEscaper.Replace(vv)))
 		case int:
 			stmt.WriteString(fmt.Sprintf("%s=%d", key, int(vv)))
 		case int64:
@@ -63,6 +67,26 @@ func parseWriterData(stmt *strings.Builder, data *map[string]interface{}) error
 	return nil
 }
 
+// tagEscaper escapes characters that would break a tag in Influx line protocol
+var tagEscaper = strings.NewReplacer(",", "\\,", "=", "\\=", " ", "\\ ")
+
+// Helper function to parse tags as a DB string. Unlike fields, tag values are never quoted
+func parseTagData(stmt *strings.Builder, tags *map[string]interface{}) {
+	// Influx prefers tags sorted by key
+	keys := make([]string, 0, len(*tags))
+	for key := range *tags {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for i, key := range keys {
+		if i > 0 {
+			stmt.WriteString(",")
+		}
+		stmt.WriteString(fmt.Sprintf("%s=%s", tagEscaper.Replace(key), tagEscaper.Replace(fmt.Sprintf("%v", (*tags)[key]))))
+	}
+}
+
 // Insert will prepare a new write statement and pass it along
 func (database *Database) Insert(measurement string, tags map[string]interface{}, fields map[string]interface{}) error {
 	if database == nil {
@@ -75,9 +99,7 @@ func (database *Database) Insert(measurement string, tags map[string]interface{}
 
 	// Write tags first
 	var tagstring strings.Builder
-	if err := parseWriterData(&tagstring, &tags); err != nil {
-		return err
-	}
+	parseTagData(&tagstring, &tags)
 
 	// Check if any tags were added. If not, remove the trailing comma
 	if tagstring.String() != "" {
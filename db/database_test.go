@@ -0,0 +1,30 @@
+package db
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseWriterData(t *testing.T) {
+	cases := []struct {
+		value interface{}
+		want  string
+	}{
+		{"TRUE", `value="TRUE"`},
+		{`{"a":1}`, `value="{\"a\":1}"`},
+		{`C:\path`, `value="C:\\path"`},
+		{true, `value=true`},
+		{5, `value=5`},
+	}
+
+	for _, c := range cases {
+		var stmt strings.Builder
+		if err := parseWriterData(&stmt, &map[string]interface{}{"value": c.value}); err != nil {
+			t.Errorf("parseWriterData(%v) returned %s", c.value, err.Error())
+			continue
+		}
+		if stmt.String() != c.want {
+			t.Errorf("parseWriterData(%v) == %s, want %s", c.value, stmt.String(), c.want)
+		}
+	}
+}
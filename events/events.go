@@ -0,0 +1,204 @@
+// Package events keeps a short backlog of session and settings changes, and streams them as Server-Sent Events
+package events
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/qcasey/MDroid-Core-Public/format"
+	"github.com/qcasey/MDroid-Core-Public/format/response"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	// backlogSize is how many events are kept for clients resuming with Last-Event-ID
+	backlogSize = 500
+	// bufferSize is how many events a client can fall behind before it's disconnected
+	bufferSize = 256
+)
+
+// Event is a single change, either to the session or to settings
+type Event struct {
+	ID   uint64      `json:"id"`
+	Type string      `json:"type"`
+	Name string      `json:"name"` // Session name, or COMPONENT/SETTING for settings
+	Data interface{} `json:"data"`
+}
+
+type client struct {
+	send   chan Event
+	filter format.Filter
+	types  []string
+}
+
+var (
+	lastID  uint64
+	backlog []Event
+	clients = make(map[*client]bool)
+	mutex   sync.Mutex
+)
+
+// matches returns if the client is subscribed to the event.
+// Settings match on either COMPONENT/SETTING or just the setting name, since globs don't cross the /
+func (c *client) matches(e Event) bool {
+	if len(c.types) > 0 && !format.StringInSlice(e.Type, c.types) {
+		return false
+	}
+	if c.filter.Matches(e.Name) {
+		return true
+	}
+	if i := strings.LastIndex(e.Name, "/"); i >= 0 {
+		return c.filter.Matches(e.Name[i+1:])
+	}
+	return false
+}
+
+// Publish records a new event, and sends it to all subscribed clients.
+// Clients too far behind are disconnected, they can resume from the backlog
+func Publish(eventType string, name string, data interface{}) {
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	lastID++
+	e := Event{ID: lastID, Type: eventType, Name: name, Data: data}
+
+	backlog = append(backlog, e)
+	if len(backlog) > backlogSize {
+		backlog = backlog[len(backlog)-backlogSize:]
+	}
+
+	for c := range clients {
+		if !c.matches(e) {
+			continue
+		}
+		select {
+		case c.send <- e:
+		default:
+			delete(clients, c)
+			close(c.send)
+		}
+	}
+}
+
+// subscribe adds a new client, returning any backlogged events after lastEventID it should be sent first
+func subscribe(c *client, lastEventID uint64, resuming bool) []Event {
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	clients[c] = true
+	if !resuming {
+		return nil
+	}
+
+	// An ID from before a restart can't be resumed, send everything we have
+	if lastEventID > lastID {
+		lastEventID = 0
+	}
+
+	var missed []Event
+	for _, e := range backlog {
+		if e.ID > lastEventID && c.matches(e) {
+			missed = append(missed, e)
+		}
+	}
+	return missed
+}
+
+func unsubscribe(c *client) {
+	mutex.Lock()
+	defer mutex.Unlock()
+	if clients[c] {
+		delete(clients, c)
+		close(c.send)
+	}
+}
+
+// HandleStream sends session and setting events as Server-Sent Events.
+// Session names, or COMPONENT/SETTING for settings, are filtered with the name, prefix and match query parameters,
+// and event types with event (i.e. event=setting). Settings also match on their name alone, so match=*_POWER
+// or match=POWER work as well as match=*/POWER. Last-Event-ID resumes from the backlog
+func HandleStream(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		response.WriteNew(&w, r, response.JSONResponse{Output: "Streaming is not supported", OK: false})
+		return
+	}
+
+	query := r.URL.Query()
+	filter, err := format.ParseFilter(query)
+	if err != nil {
+		response.WriteNew(&w, r, response.JSONResponse{Output: err.Error(), OK: false})
+		return
+	}
+
+	c := &client{send: make(chan Event, bufferSize), filter: filter}
+	for _, eventType := range strings.Split(query.Get("event"), ",") {
+		if eventType = strings.TrimSpace(eventType); eventType != "" {
+			c.types = append(c.types, strings.ToLower(eventType))
+		}
+	}
+
+	// EventSource sends the header when reconnecting, allow it as a query parameter for simpler clients
+	lastEventIDString := r.Header.Get("Last-Event-ID")
+	if lastEventIDString == "" {
+		lastEventIDString = query.Get("lastEventId")
+	}
+	lastEventID, err := strconv.ParseUint(lastEventIDString, 10, 64)
+	resuming := err == nil
+
+	missed := subscribe(c, lastEventID, resuming)
+	defer unsubscribe(c)
+	log.Info().Msgf("Opened event stream to %s, resending %d events", r.RemoteAddr, len(missed))
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+
+	for _, e := range missed {
+		if err := writeEvent(w, e); err != nil {
+			return
+		}
+	}
+	flusher.Flush()
+
+	ping := time.NewTicker(15 * time.Second)
+	defer ping.Stop()
+
+	for {
+		var err error
+		select {
+		case e, ok := <-c.send:
+			if !ok {
+				log.Warn().Msgf("Event stream to %s fell behind, closing", r.RemoteAddr)
+				return
+			}
+			err = writeEvent(w, e)
+		case <-ping.C:
+			_, err = fmt.Fprint(w, ": ping\n\n")
+		case <-r.Context().Done():
+			log.Info().Msgf("Closed event stream to %s", r.RemoteAddr)
+			return
+		}
+
+		if err != nil {
+			log.Error().Msgf("Failed to write to event stream %s: %s", r.RemoteAddr, err.Error())
+			return
+		}
+		flusher.Flush()
+	}
+}
+
+func writeEvent(w http.ResponseWriter, e Event) error {
+	data, err := json.Marshal(e.Data)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", e.ID, e.Type, data)
+	return err
+}
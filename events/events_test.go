@@ -0,0 +1,96 @@
+package events
+
+import (
+	"context"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/qcasey/MDroid-Core-Public/format"
+)
+
+func TestMatches(t *testing.T) {
+	tables := []struct {
+		query  string
+		event  Event
+		output bool
+	}{
+		{"", Event{Type: "session", Name: "MAIN_VOLTAGE"}, true},
+		{"name=main_voltage", Event{Type: "session", Name: "MAIN_VOLTAGE"}, true},
+		{"prefix=TABLET", Event{Type: "session", Name: "MAIN_VOLTAGE"}, false},
+		{"match=*_VOLTAGE", Event{Type: "session", Name: "MAIN_VOLTAGE"}, true},
+		{"match=*_POWER", Event{Type: "setting", Name: "MDROID/TABLET_POWER"}, true},
+		{"match=*/POWER", Event{Type: "setting", Name: "BOARD/POWER"}, true},
+		{"name=POWER", Event{Type: "setting", Name: "BOARD/POWER"}, true},
+		{"prefix=BOARD", Event{Type: "setting", Name: "BOARD/POWER"}, true},
+		{"match=*_POWER", Event{Type: "setting", Name: "BOARD/POWER"}, false},
+		{"event=setting", Event{Type: "session", Name: "MAIN_VOLTAGE"}, false},
+		{"event=setting&name=POWER", Event{Type: "setting", Name: "BOARD/POWER"}, true},
+	}
+
+	for _, table := range tables {
+		query, _ := url.ParseQuery(table.query)
+		filter, err := format.ParseFilter(query)
+		if err != nil {
+			t.Fatalf("ParseFilter(%s) failed: %s", table.query, err.Error())
+		}
+		c := &client{filter: filter}
+		if eventType := query.Get("event"); eventType != "" {
+			c.types = []string{eventType}
+		}
+		if output := c.matches(table.event); output != table.output {
+			t.Errorf("%s matching %s %s = %v; want %v", table.query, table.event.Type, table.event.Name, output, table.output)
+		}
+	}
+}
+
+func TestResume(t *testing.T) {
+	mutex.Lock()
+	backlog = nil
+	start := lastID
+	mutex.Unlock()
+
+	Publish("session", "MAIN_VOLTAGE", 12)
+	Publish("setting", "BOARD/POWER", "ON")
+	Publish("session", "MAIN_VOLTAGE", 13)
+
+	// IDs are relative to the event before the first published
+	tables := []struct {
+		lastEventID string
+		query       string
+		ids         []uint64
+	}{
+		{"", "", nil},
+		{"0", "", []uint64{1, 2, 3}},
+		{"2", "", []uint64{3}},
+		{"1", "name=MAIN_VOLTAGE", []uint64{3}},
+		{"100", "", []uint64{1, 2, 3}}, // From before a restart
+	}
+
+	for _, table := range tables {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		r := httptest.NewRequest("GET", "/events?"+table.query, nil).WithContext(ctx)
+		if table.lastEventID != "" {
+			id, _ := strconv.ParseUint(table.lastEventID, 10, 64)
+			r.Header.Set("Last-Event-ID", strconv.FormatUint(start+id, 10))
+		}
+		rr := httptest.NewRecorder()
+		HandleStream(rr, r)
+
+		var want, got []string
+		for _, id := range table.ids {
+			want = append(want, "id: "+strconv.FormatUint(start+id, 10))
+		}
+		for _, line := range strings.Split(rr.Body.String(), "\n") {
+			if strings.HasPrefix(line, "id: ") {
+				got = append(got, line)
+			}
+		}
+		if strings.Join(got, ",") != strings.Join(want, ",") {
+			t.Errorf("Resuming from %s with %q sent %v; want %v", table.lastEventID, table.query, got, want)
+		}
+	}
+}
@@ -0,0 +1,147 @@
+// Package checksum verifies the {checksum} route parameter sent by serial bridges and remote clients
+package checksum
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash/crc32"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/gorilla/mux"
+	"github.com/qcasey/MDroid-Core-Public/format/response"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	// CRC32 is an IEEE CRC32, as 8 hex characters
+	CRC32 = "CRC32"
+	// HMAC is a SHA256 HMAC with the shared CHECKSUM_KEY, as 64 hex characters
+	HMAC = "HMAC"
+	// None skips verification entirely
+	None = "NONE"
+)
+
+var config = struct {
+	algorithm string
+	key       []byte
+	mutex     sync.RWMutex
+}{algorithm: None}
+
+// Setup reads CHECKSUM_ALGORITHM and CHECKSUM_KEY from the MDROID config.
+// Checksums aren't verified unless CHECKSUM_ALGORITHM is set, so existing clients keep working
+func Setup(configAddr *map[string]string) {
+	configMap := *configAddr
+
+	config.mutex.Lock()
+	defer config.mutex.Unlock()
+
+	config.algorithm = None
+	if algorithm, ok := configMap["CHECKSUM_ALGORITHM"]; ok {
+		switch algorithm {
+		case CRC32, HMAC, None:
+			config.algorithm = algorithm
+		default:
+			log.Error().Msgf("Unknown CHECKSUM_ALGORITHM %s, using %s", algorithm, CRC32)
+			config.algorithm = CRC32
+		}
+	}
+	config.key = []byte(configMap["CHECKSUM_KEY"])
+
+	if config.algorithm == HMAC && len(config.key) == 0 {
+		log.Error().Msg("CHECKSUM_ALGORITHM is HMAC but CHECKSUM_KEY is empty, all checksums will be rejected")
+	}
+	log.Info().Msgf("Verifying checksums with %s", config.algorithm)
+}
+
+// Compute returns the checksum for a message with the configured algorithm
+func Compute(message []byte) (string, error) {
+	config.mutex.RLock()
+	defer config.mutex.RUnlock()
+
+	switch config.algorithm {
+	case HMAC:
+		if len(config.key) == 0 {
+			return "", fmt.Errorf("No CHECKSUM_KEY set for HMAC")
+		}
+		mac := hmac.New(sha256.New, config.key)
+		mac.Write(message)
+		return hex.EncodeToString(mac.Sum(nil)), nil
+	case None:
+		return "", nil
+	}
+	return fmt.Sprintf("%08x", crc32.ChecksumIEEE(message)), nil
+}
+
+// Verify checks the request's {checksum} route parameter. The checksum covers the
+// (unescaped) path before the checksum, followed by the request body, i.e. "/session/MAIN_VOLTAGE{"value":12.5}"
+func Verify(r *http.Request) error {
+	sent, ok := mux.Vars(r)["checksum"]
+	if !ok {
+		return nil
+	}
+
+	config.mutex.RLock()
+	algorithm := config.algorithm
+	config.mutex.RUnlock()
+	if algorithm == None {
+		return nil
+	}
+
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return err
+	}
+
+	// Put body back for the real handler
+	r.Body.Close()
+	r.Body = ioutil.NopCloser(bytes.NewBuffer(body))
+
+	path := strings.TrimSuffix(r.URL.Path, "/"+sent)
+	expected, err := Compute(append([]byte(path), body...))
+	if err != nil {
+		return err
+	}
+
+	if !hmac.Equal([]byte(strings.ToLower(sent)), []byte(expected)) {
+		return fmt.Errorf("%s checksum mismatch for %s. Possibly a failed serial transmission?", algorithm, path)
+	}
+	return nil
+}
+
+// Required wraps a route without a {checksum} parameter, rejecting every request once a CHECKSUM_ALGORITHM is set.
+// Otherwise dropping the checksum from a request would skip verification entirely
+func Required(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		config.mutex.RLock()
+		algorithm := config.algorithm
+		config.mutex.RUnlock()
+
+		if algorithm != None {
+			err := fmt.Errorf("%s checksum required for %s", algorithm, r.URL.Path)
+			log.Error().Msg(err.Error())
+			response.Statistics.ChecksumFailures++
+			response.WriteNew(&w, r, response.JSONResponse{Output: err.Error(), OK: false})
+			return
+		}
+		next(w, r)
+	}
+}
+
+// Handler wraps a route with a {checksum} parameter, rejecting requests that don't match
+func Handler(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if err := Verify(r); err != nil {
+			log.Error().Msg(err.Error())
+			response.Statistics.ChecksumFailures++
+			response.WriteNew(&w, r, response.JSONResponse{Output: err.Error(), OK: false})
+			return
+		}
+		next(w, r)
+	}
+}
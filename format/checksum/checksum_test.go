@@ -0,0 +1,75 @@
+package checksum
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash/crc32"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gorilla/mux"
+)
+
+func TestVerify(t *testing.T) {
+	const path = "/session/MAIN_VOLTAGE"
+	body := []byte(`{"value":12.5}`)
+	crc := fmt.Sprintf("%08x", crc32.ChecksumIEEE(append([]byte(path), body...)))
+	mac := hmac.New(sha256.New, []byte("secret"))
+	mac.Write(append([]byte(path), body...))
+	sha := hex.EncodeToString(mac.Sum(nil))
+
+	tables := []struct {
+		config   map[string]string
+		checksum string
+		valid    bool
+	}{
+		{map[string]string{}, "anything", true},
+		{map[string]string{"CHECKSUM_ALGORITHM": "CRC32"}, crc, true},
+		{map[string]string{"CHECKSUM_ALGORITHM": "CRC32"}, "00000000", false},
+		{map[string]string{"CHECKSUM_ALGORITHM": "HMAC", "CHECKSUM_KEY": "secret"}, sha, true},
+		{map[string]string{"CHECKSUM_ALGORITHM": "HMAC", "CHECKSUM_KEY": "wrong"}, sha, false},
+		{map[string]string{"CHECKSUM_ALGORITHM": "HMAC", "CHECKSUM_KEY": "secret"}, crc, false},
+		{map[string]string{"CHECKSUM_ALGORITHM": "HMAC"}, sha, false},
+	}
+
+	for _, table := range tables {
+		Setup(&table.config)
+		r := httptest.NewRequest("POST", path+"/"+table.checksum, bytes.NewReader(body))
+		r = mux.SetURLVars(r, map[string]string{"name": "MAIN_VOLTAGE", "checksum": table.checksum})
+
+		err := Verify(r)
+		if (err == nil) != table.valid {
+			t.Errorf("Verify with %v and checksum %s returned %v; want valid %v", table.config, table.checksum, err, table.valid)
+		}
+	}
+}
+
+func TestRequired(t *testing.T) {
+	tables := []struct {
+		config map[string]string
+		method string
+		code   int
+	}{
+		{map[string]string{}, "POST", http.StatusOK},
+		{map[string]string{"CHECKSUM_ALGORITHM": "NONE"}, "POST", http.StatusOK},
+		{map[string]string{"CHECKSUM_ALGORITHM": "CRC32"}, "POST", http.StatusBadRequest},
+		{map[string]string{"CHECKSUM_ALGORITHM": "CRC32"}, "GET", http.StatusBadRequest},
+		{map[string]string{}, "GET", http.StatusOK},
+	}
+
+	handler := Required(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+	for _, table := range tables {
+		Setup(&table.config)
+		rr := httptest.NewRecorder()
+		handler(rr, httptest.NewRequest(table.method, "/session/MAIN_VOLTAGE", nil))
+		if rr.Code != table.code {
+			t.Errorf("%s with %v returned %d; want %d", table.method, table.config, rr.Code, table.code)
+		}
+	}
+}
@@ -0,0 +1,84 @@
+package format
+
+import (
+	"net/url"
+	"path"
+	"strings"
+)
+
+// Filter matches names exactly, by prefix, or by glob (i.e. *_VOLTAGE).
+// An empty filter matches everything
+type Filter struct {
+	Names    []string `json:"name,omitempty"`
+	Prefixes []string `json:"prefix,omitempty"`
+	Globs    []string `json:"match,omitempty"`
+}
+
+// ParseFilter reads a filter from the name, prefix and match query parameters.
+// Each can be repeated, or hold a comma separated list
+func ParseFilter(query url.Values) (Filter, error) {
+	f := Filter{
+		Names:    splitQuery(query["name"]),
+		Prefixes: splitQuery(query["prefix"]),
+		Globs:    splitQuery(query["match"]),
+	}
+	return f.Normalize()
+}
+
+// splitQuery flattens repeated and comma separated query values
+func splitQuery(values []string) []string {
+	var output []string
+	for _, value := range values {
+		for _, v := range strings.Split(value, ",") {
+			if v = strings.TrimSpace(v); v != "" {
+				output = append(output, v)
+			}
+		}
+	}
+	return output
+}
+
+// Normalize formats all names and patterns the same way names are stored, and validates globs
+func (f Filter) Normalize() (Filter, error) {
+	var n Filter
+	for _, name := range f.Names {
+		n.Names = append(n.Names, Name(name))
+	}
+	for _, prefix := range f.Prefixes {
+		n.Prefixes = append(n.Prefixes, Name(prefix))
+	}
+	for _, glob := range f.Globs {
+		glob = Name(glob)
+		if _, err := path.Match(glob, ""); err != nil {
+			return f, err
+		}
+		n.Globs = append(n.Globs, glob)
+	}
+	return n, nil
+}
+
+// IsEmpty returns true if the filter has nothing to match against
+func (f Filter) IsEmpty() bool {
+	return len(f.Names) == 0 && len(f.Prefixes) == 0 && len(f.Globs) == 0
+}
+
+// Matches returns true if the name satisfies any part of the filter, or if the filter is empty
+func (f Filter) Matches(name string) bool {
+	if f.IsEmpty() {
+		return true
+	}
+	if StringInSlice(name, f.Names) {
+		return true
+	}
+	for _, prefix := range f.Prefixes {
+		if strings.HasPrefix(name, prefix) {
+			return true
+		}
+	}
+	for _, glob := range f.Globs {
+		if ok, _ := path.Match(glob, name); ok {
+			return true
+		}
+	}
+	return false
+}
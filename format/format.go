@@ -4,7 +4,9 @@ package format
 import (
 	"crypto/rand"
 	"fmt"
+	"reflect"
 	"regexp"
+	"runtime"
 	"strings"
 	"time"
 )
@@ -92,3 +94,15 @@ func NewShortUUID() (string, error) {
 	}
 	return fmt.Sprintf("%x", b[0:4]), nil
 }
+
+// FuncName returns the name of a function, i.e. main.keyState, for logging hooks
+func FuncName(function interface{}) string {
+	f := runtime.FuncForPC(reflect.ValueOf(function).Pointer())
+	if f == nil {
+		return "unknown"
+	}
+
+	// Drop the package path
+	name := f.Name()
+	return name[strings.LastIndex(name, "/")+1:]
+}
@@ -1,6 +1,9 @@
 package format
 
-import "testing"
+import (
+	"net/url"
+	"testing"
+)
 
 func TestName(t *testing.T) {
 	tables := []struct {
@@ -61,3 +64,38 @@ func TestIsPositiveRequest(t *testing.T) {
 		}
 	}
 }
+
+func TestFilter(t *testing.T) {
+	tables := []struct {
+		query  string
+		input  string
+		output bool
+	}{
+		{"", "MAIN_VOLTAGE", true},
+		{"name=main_voltage", "MAIN_VOLTAGE", true},
+		{"name=MAIN_VOLTAGE,AUX_VOLTAGE", "AUX_VOLTAGE", true},
+		{"name=MAIN_VOLTAGE", "MAIN_VOLTAGE_RAW", false},
+		{"prefix=TABLET_", "TABLET_POWER", true},
+		{"prefix=TABLET_", "BOARD_POWER", false},
+		{"match=*_VOLTAGE", "AUX_VOLTAGE", true},
+		{"match=*_VOLTAGE", "AUX_VOLTAGE_RAW", false},
+		{"match=SEAT_MEMORY_?&prefix=TABLET_", "SEAT_MEMORY_2", true},
+	}
+
+	for _, table := range tables {
+		query, _ := url.ParseQuery(table.query)
+		f, err := ParseFilter(query)
+		if err != nil {
+			t.Errorf("ParseFilter(%s) failed: %s", table.query, err.Error())
+			continue
+		}
+		if got := f.Matches(table.input); got != table.output {
+			t.Errorf("ParseFilter(%s).Matches(%s) = %t; want %t", table.query, table.input, got, table.output)
+		}
+	}
+
+	query, _ := url.ParseQuery("match=[A-")
+	if _, err := ParseFilter(query); err == nil {
+		t.Errorf("ParseFilter(match=[A-) did not fail")
+	}
+}
@@ -18,15 +18,19 @@ type JSONResponse struct {
 	ID     int         `json:"id,omitempty"`
 }
 
+// SourceHeader names where a request came from, for requests forwarded from other ingest paths like MQTT
+const SourceHeader = "X-MDroid-Source"
+
 // stat for requests, provided they go through our Write
 type stat struct {
-	Failures      int       `json:"failures,omitempty"`
-	Successes     int       `json:"successes,omitempty"`
-	Total         int       `json:"total,omitempty"`
-	TotalSize     int64     `json:"totalSize,omitempty"`
-	SessionValues int       `json:"sessionValues,omitempty"`
-	TimeStarted   time.Time `json:"timeStarted,omitempty"`
-	TimeRunning   float64   `json:"timeRunning,omitempty"`
+	Failures         int       `json:"failures,omitempty"`
+	Successes        int       `json:"successes,omitempty"`
+	ChecksumFailures int       `json:"checksumFailures,omitempty"`
+	Total            int       `json:"total,omitempty"`
+	TotalSize        int64     `json:"totalSize,omitempty"`
+	SessionValues    int       `json:"sessionValues,omitempty"`
+	TimeStarted      time.Time `json:"timeStarted,omitempty"`
+	TimeRunning      float64   `json:"timeRunning,omitempty"`
 }
 
 // Statistics counts various program data
@@ -56,6 +60,8 @@ func (response *JSONResponse) Write(w *http.ResponseWriter, r *http.Request) {
 			writer.WriteHeader(http.StatusBadRequest)
 		} else if response.Status == "error" {
 			writer.WriteHeader(http.StatusNoContent)
+		} else if response.Status == "conflict" {
+			writer.WriteHeader(http.StatusConflict)
 		} else {
 			writer.WriteHeader(http.StatusBadRequest)
 		}
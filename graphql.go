@@ -13,18 +13,26 @@ var queryType = graphql.NewObject(
 	graphql.ObjectConfig{
 		Name: "Query",
 		Fields: graphql.Fields{
-			"gps":          gps.Query,
-			"stat":         system.Query,
-			"sessionList":  sessions.SessionQuery,
-			"settingsList": settings.SettingQuery,
+			"gps":              gps.Query,
+			"stat":             system.Query,
+			"sessionList":      sessions.SessionQuery,
+			"settingsList":     settings.SettingQuery,
+			"sessionSchema":    sessions.SchemaQuery,
+			"sessionHooks":     sessions.HookQuery,
+			"settingHooks":     settings.HookQuery,
+			"settingsProfiles": settings.ProfileQuery,
 		},
 	})
 
 var mutationType = graphql.NewObject(graphql.ObjectConfig{
 	Name: "Mutation",
 	Fields: graphql.Fields{
-		"setSession": sessions.SessionMutation,
-		"setSetting": settings.SettingMutation,
+		"setSession":         sessions.SessionMutation,
+		"setSessions":        sessions.SessionBatchMutation,
+		"setSetting":         settings.SettingMutation,
+		"setSettingsProfile": settings.ProfileMutation,
+		"deleteSetting":      settings.DeleteMutation,
+		"deleteComponent":    settings.DeleteComponentMutation,
 	},
 })
 
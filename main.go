@@ -1,19 +1,29 @@
 package main
 
 import (
+	"os"
+	"os/signal"
+	"syscall"
+
 	"github.com/gorilla/mux"
 	//bluetooth "github.com/qcasey/MDroid-Bluetooth"
 	"github.com/qcasey/MDroid-Core-Public/db"
+	"github.com/qcasey/MDroid-Core-Public/format/checksum"
 	"github.com/qcasey/MDroid-Core-Public/mqtt"
 	"github.com/qcasey/MDroid-Core-Public/mserial"
 	"github.com/qcasey/MDroid-Core-Public/pybus"
+	"github.com/qcasey/MDroid-Core-Public/sessions"
 	"github.com/qcasey/MDroid-Core-Public/sessions/gps"
 	"github.com/qcasey/MDroid-Core-Public/sessions/system"
+	"github.com/qcasey/MDroid-Core-Public/settings"
+	"github.com/rs/zerolog/log"
 )
 
 func main() {
 	// Run through the config file and retrieve some settings
 	configMap := parseConfig()
+	saveOnExit()
+	checksum.Setup(configMap)
 
 	// Init router
 	router := mux.NewRouter()
@@ -42,3 +52,16 @@ func main() {
 
 	Start(router)
 }
+
+// saveOnExit snapshots the session, and any pending settings, when we're asked to stop
+func saveOnExit() {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-signals
+		log.Info().Msg("Stopping MDroid Service, saving session")
+		sessions.SaveFile()
+		settings.Flush()
+		os.Exit(0)
+	}()
+}
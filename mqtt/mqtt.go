@@ -10,6 +10,7 @@ import (
 	"time"
 
 	mqtt "github.com/eclipse/paho.mqtt.golang"
+	"github.com/qcasey/MDroid-Core-Public/format/response"
 	logger "github.com/rs/zerolog/log"
 )
 
@@ -46,7 +47,7 @@ var f mqtt.MessageHandler = func(client mqtt.Client, msg mqtt.Message) {
 	request := message{}
 	err := json.Unmarshal(msg.Payload(), &request)
 
-	var response *http.Response
+	var resp *http.Response
 	const errMsg = "Could not forward request from websocket. Got error: %s"
 
 	if request.Method == "POST" {
@@ -57,10 +58,12 @@ var f mqtt.MessageHandler = func(client mqtt.Client, msg mqtt.Message) {
 			return
 		}
 		req.Header.Set("Content-Type", "application/json")
+		// Mark the request, so values record where they came from
+		req.Header.Set(response.SourceHeader, "mqtt")
 		client := &http.Client{}
-		response, err = client.Do(req)
+		resp, err = client.Do(req)
 	} else if request.Method == "GET" {
-		response, err = http.Get(fmt.Sprintf("http://localhost:5353%s", request.Path))
+		resp, err = http.Get(fmt.Sprintf("http://localhost:5353%s", request.Path))
 	}
 
 	if err != nil {
@@ -68,7 +71,7 @@ var f mqtt.MessageHandler = func(client mqtt.Client, msg mqtt.Message) {
 		return
 	}
 
-	defer response.Body.Close()
+	defer resp.Body.Close()
 	return
 }
 
@@ -81,6 +84,12 @@ func Publish(topic string, message string) {
 	token.Wait()
 }
 
+// Clear removes the retained message from the given topic and waits
+func Clear(topic string) {
+	// An empty retained message replaces the last one, and isn't kept itself
+	Publish(topic, "")
+}
+
 // IsConnected returns if the MQTT client has finished setting up and is connected
 func IsConnected() bool {
 	if !finishedSetup {
@@ -6,6 +6,7 @@ import (
 
 	"github.com/gorilla/mux"
 	"github.com/qcasey/MDroid-Core-Public/format"
+	"github.com/qcasey/MDroid-Core-Public/format/checksum"
 	"github.com/qcasey/MDroid-Core-Public/format/response"
 	"github.com/rs/zerolog/log"
 	"github.com/tarm/serial"
@@ -76,8 +77,8 @@ func (*Module) SetRoutes(router *mux.Router) {
 	//
 	// Serial routes
 	//
-	router.HandleFunc("/serial/{command}/{checksum}", WriteSerialHandler).Methods("POST", "GET")
-	router.HandleFunc("/serial/{command}", WriteSerialHandler).Methods("POST", "GET")
+	router.HandleFunc("/serial/{command}/{checksum}", checksum.Handler(WriteSerialHandler)).Methods("POST", "GET")
+	router.HandleFunc("/serial/{command}", checksum.Required(WriteSerialHandler)).Methods("POST", "GET")
 
 	router.HandleFunc("/gyros", getGyroMeasurements).Methods("GET")
 }
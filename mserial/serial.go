@@ -2,9 +2,9 @@ package mserial
 
 import (
 	"bufio"
+	"bytes"
 	"encoding/json"
 	"fmt"
-	"strconv"
 	"strings"
 	"time"
 
@@ -121,9 +121,11 @@ func read(serialDevice *serial.Port) (interface{}, error) {
 		return nil, err
 	}
 
-	// Parse serial data
+	// Parse serial data, keeping numbers as json.Number so ints and floats can be told apart
 	var data interface{}
-	json.Unmarshal(msg, &data)
+	decoder := json.NewDecoder(bytes.NewReader(msg))
+	decoder.UseNumber()
+	decoder.Decode(&data)
 	return data, nil
 }
 
@@ -161,31 +163,17 @@ func parseJSON(marshalledJSON interface{}) {
 	// Switch through various types of JSON data
 	for key, value := range data {
 		switch vv := value.(type) {
-		case bool:
-			sessions.SetValue(strings.ToUpper(key), strings.ToUpper(strconv.FormatBool(vv)))
+		case bool, json.Number, float64:
+			sessions.SetValueFrom(strings.ToUpper(key), vv, sessions.SourceSerial)
 		case string:
-			sessions.SetValue(strings.ToUpper(key), strings.ToUpper(vv))
-		case int:
-			sessions.SetValue(strings.ToUpper(key), strconv.Itoa(value.(int)))
-		case float32:
-			if floatValue, ok := value.(float32); ok {
-				sessions.SetValue(strings.ToUpper(key), fmt.Sprintf("%f", floatValue))
-			}
-		case float64:
-			if floatValue, ok := value.(float64); ok {
-				sessions.SetValue(strings.ToUpper(key), fmt.Sprintf("%f", floatValue))
-			}
+			sessions.SetValueFrom(strings.ToUpper(key), strings.ToUpper(vv), sessions.SourceSerial)
 		case map[string]interface{}:
+			// Gyro measurements are kept separately, any other object is a session value
 			var m Measurement
-			err := mapstructure.Decode(value, &m)
-			if err != nil {
-				log.Error().Msgf(err.Error())
-				return
-			}
-			err = addMeasurement(key, m)
-			if err != nil {
-				log.Error().Msgf(err.Error())
+			if err := mapstructure.Decode(value, &m); err == nil && addMeasurement(key, m) == nil {
+				break
 			}
+			sessions.SetValueFrom(strings.ToUpper(key), vv, sessions.SourceSerial)
 		case []interface{}:
 			log.Error().Msg(key + " is an array. Data: ")
 			for i, u := range vv {
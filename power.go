@@ -2,11 +2,13 @@ package main
 
 import (
 	"fmt"
+	"sync"
 	"time"
 
 	"github.com/qcasey/MDroid-Core-Public/format"
 	"github.com/qcasey/MDroid-Core-Public/mserial"
 	"github.com/qcasey/MDroid-Core-Public/sessions"
+	"github.com/qcasey/MDroid-Core-Public/sessions/gps"
 	"github.com/qcasey/MDroid-Core-Public/settings"
 	"github.com/rs/zerolog/log"
 )
@@ -34,6 +36,7 @@ type powerStats struct {
 	powerOnTime      time.Time
 	lastTrigger      powerTrigger
 	workingOnRequest bool
+	mutex            sync.Mutex
 }
 
 type powerTrigger struct {
@@ -49,23 +52,45 @@ var (
 	_board  = device{settings: settingDef{component: "BOARD", name: "POWER"}}
 )
 
-func (ps *powerStats) startRequest() {
+// startRequest claims the device for a request, returning false if one is already being made
+func (ps *powerStats) startRequest() bool {
+	ps.mutex.Lock()
+	defer ps.mutex.Unlock()
+	if ps.workingOnRequest {
+		return false
+	}
 	ps.workingOnRequest = true
+	return true
 }
 
 func (ps *powerStats) endRequest() {
+	ps.mutex.Lock()
 	ps.workingOnRequest = false
+	ps.mutex.Unlock()
+}
+
+// hasExpiredInputs checks if any session values an evaluation depends on are past their max age.
+// Values restored on startup are still used until then, a board that's off may never report again
+func hasExpiredInputs(name string, inputs ...string) bool {
+	for _, input := range inputs {
+		if sessions.IsExpired(input) {
+			log.Debug().Msgf("Not evaluating %s, %s has expired", name, input)
+			return true
+		}
+	}
+	return false
 }
 
 // Evaluates if the doors should be locked
 func evalAutoLock(keyIsIn string, accOn bool, wifiOn bool) {
-	// Check if request is already being made
-	if _lock.powerStats.workingOnRequest {
+	if hasExpiredInputs("auto lock", "KEY_STATE", "ACC_POWER", "WIFI_CONNECTED", "DOORS_LOCKED") {
 		return
 	}
 
-	// Very dumb lock to shoot down spam requests
-	_lock.powerStats.startRequest()
+	// Shoot down spam requests, and evaluations racing on the same unlocked state, until this one is done
+	if !_lock.powerStats.startRequest() {
+		return
+	}
 	defer _lock.powerStats.endRequest()
 
 	_lock.isOn, _lock.errors.on = sessions.GetBool("DOORS_LOCKED")
@@ -78,10 +103,6 @@ func evalAutoLock(keyIsIn string, accOn bool, wifiOn bool) {
 	}
 	if _lock.errors.target != nil {
 		log.Error().Msgf("Setting Error: %s", _lock.errors.target.Error())
-		if _lock.settings.component != "" && _lock.settings.name != "" {
-			log.Error().Msg("Setting read error for AUTOLOCK. Resetting to AUTO")
-			settings.Set(_lock.settings.component, _lock.settings.name, "AUTO")
-		}
 		return
 	}
 
@@ -92,7 +113,7 @@ func evalAutoLock(keyIsIn string, accOn bool, wifiOn bool) {
 			log.Error().Msg(_lock.errors.on.Error())
 			return
 		}
-		lockToggleTime, err := time.Parse("", lastLock.LastUpdate)
+		lockToggleTime, err := time.ParseInLocation("2006-01-02 15:04:05.999", lastLock.LastUpdate, gps.GetTimezone())
 		if err != nil {
 			log.Error().Msg(err.Error())
 			return
@@ -111,18 +132,27 @@ func evalAutoLock(keyIsIn string, accOn bool, wifiOn bool) {
 		err = mserial.AwaitText("toggleDoorLocks")
 		if err != nil {
 			log.Error().Msg(err.Error())
+			return
+		}
+
+		// Only mark the doors locked once they were toggled, unless they changed since
+		locked := sessions.Data{Name: "DOORS_LOCKED", Value: true, Quiet: true, Source: sessions.SourceHook}
+		if _, err := sessions.SetIf(locked, sessions.Precondition{Version: &lastLock.Version}); err != nil {
+			log.Info().Msgf("Not marking doors locked: %s", err.Error())
 		}
 	}
 }
 
 // Evaluates if the board should be put to sleep
 func evalAutoSleep(keyIsIn string, accOn bool, wifiOn bool) {
+	if hasExpiredInputs("auto sleep", "KEY_STATE", "ACC_POWER", "WIFI_CONNECTED") {
+		return
+	}
+
 	sleepEnabled, err := settings.Get("MDROID", "SLEEP")
 
 	if err != nil {
 		log.Error().Msgf("Setting Error: %s", err.Error())
-		log.Error().Msg("Setting read error for SLEEP. Resetting to AUTO")
-		settings.Set("MDROID", "SLEEP", "AUTO")
 		return
 	}
 
@@ -144,6 +174,10 @@ func evalAutoSleep(keyIsIn string, accOn bool, wifiOn bool) {
 
 // Evaluates if the angel eyes should be on, and then passes that struct along as generic power module
 func evalAngelEyesPower(keyIsIn string) {
+	if hasExpiredInputs("angel eyes", "KEY_STATE", "LIGHT_SENSOR_ON", "ANGEL_EYES_POWER") {
+		return
+	}
+
 	_angel.isOn, _angel.errors.on = sessions.GetBool("ANGEL_EYES_POWER")
 	_angel.target, _angel.errors.target = settings.Get(_angel.settings.component, _angel.settings.name)
 	lightSensor := sessions.GetBoolDefault("LIGHT_SENSOR_ON", false)
@@ -157,6 +191,10 @@ func evalAngelEyesPower(keyIsIn string) {
 
 // Evaluates if the video boards should be on, and then passes that struct along as generic power module
 func evalVideoPower(keyIsIn string, accOn bool, wifiOn bool) {
+	if hasExpiredInputs("video", "KEY_STATE", "ACC_POWER", "WIFI_CONNECTED", "BOARD_POWER") {
+		return
+	}
+
 	_board.isOn, _board.errors.on = sessions.GetBool("BOARD_POWER")
 	_board.target, _board.errors.target = settings.Get(_board.settings.component, _board.settings.name)
 	startedRecently := time.Since(sessions.GetStartTime()) < time.Minute*5
@@ -170,6 +208,10 @@ func evalVideoPower(keyIsIn string, accOn bool, wifiOn bool) {
 
 // Evaluates if the tablet should be on, and then passes that struct along as generic power module
 func evalTabletPower(keyIsIn string, accOn bool, wifiOn bool) {
+	if hasExpiredInputs("tablet", "KEY_STATE", "ACC_POWER", "WIFI_CONNECTED", "TABLET_POWER") {
+		return
+	}
+
 	_tablet.isOn, _tablet.errors.on = sessions.GetBool("TABLET_POWER")
 	_tablet.target, _tablet.errors.target = settings.Get(_tablet.settings.component, _tablet.settings.name)
 	startedRecently := time.Since(sessions.GetStartTime()) < time.Minute*5
@@ -183,22 +225,15 @@ func evalTabletPower(keyIsIn string, accOn bool, wifiOn bool) {
 
 // Error check against module's status fetches, then check if we're powering on or off
 func genericPowerTrigger(shouldBeOn bool, reason string, name string, module *device) {
-	// Check if request is already being made
-	if module.powerStats.workingOnRequest {
+	// Shoot down spam requests until this one is done
+	if !module.powerStats.startRequest() {
 		return
 	}
-
-	// Very dumb lock to shoot down spam requests
-	module.powerStats.startRequest()
 	defer module.powerStats.endRequest()
 
 	// Handle error in fetches
 	if module.errors.target != nil {
 		log.Error().Msgf("Setting Error: %s", module.errors.target.Error())
-		if module.settings.component != "" && module.settings.name != "" {
-			log.Error().Msgf("Setting read error for %s. Resetting to AUTO", name)
-			settings.Set(module.settings.component, module.settings.name, "AUTO")
-		}
 		return
 	}
 	if module.errors.on != nil {
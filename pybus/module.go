@@ -4,6 +4,7 @@ import (
 	"time"
 
 	"github.com/gorilla/mux"
+	"github.com/qcasey/MDroid-Core-Public/format/checksum"
 )
 
 // Module begins module init
@@ -31,10 +32,10 @@ func (*Module) SetRoutes(router *mux.Router) {
 	//
 	// PyBus Routes
 	//
-	router.HandleFunc("/pybus/{src}/{dest}/{data}/{checksum}", StartRoutine).Methods("POST")
-	router.HandleFunc("/pybus/{src}/{dest}/{data}", StartRoutine).Methods("POST")
-	router.HandleFunc("/pybus/{command}/{checksum}", StartRoutine).Methods("GET")
-	router.HandleFunc("/pybus/{command}", StartRoutine).Methods("GET")
+	router.HandleFunc("/pybus/{src}/{dest}/{data}/{checksum}", checksum.Handler(StartRoutine)).Methods("POST")
+	router.HandleFunc("/pybus/{src}/{dest}/{data}", checksum.Required(StartRoutine)).Methods("POST")
+	router.HandleFunc("/pybus/{command}/{checksum}", checksum.Handler(StartRoutine)).Methods("GET")
+	router.HandleFunc("/pybus/{command}", checksum.Required(StartRoutine)).Methods("GET")
 
 	//
 	// Catch-Alls for (hopefully) a pre-approved pybus function
@@ -125,11 +125,11 @@ func ParseCommand(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 		doorStatus, _ := sessions.Get("DOORS_LOCKED")
-		if mserial.Writer != nil && isPositive && doorStatus.Value == "FALSE" ||
-			mserial.Writer != nil && !isPositive && doorStatus.Value == "TRUE" {
+		if mserial.Writer != nil && isPositive && doorStatus.ValueString() == "FALSE" ||
+			mserial.Writer != nil && !isPositive && doorStatus.ValueString() == "TRUE" {
 			mserial.PushText("toggleDoorLocks")
 		} else {
-			log.Info().Msgf("Request to %s doors denied, door status is %s", command, doorStatus.Value)
+			log.Info().Msgf("Request to %s doors denied, door status is %s", command, doorStatus.ValueString())
 		}
 	case "WINDOW":
 		if command == "POPDOWN" {
@@ -10,7 +10,9 @@ import (
 	"time"
 
 	"github.com/gorilla/mux"
+	"github.com/qcasey/MDroid-Core-Public/events"
 	"github.com/qcasey/MDroid-Core-Public/format"
+	"github.com/qcasey/MDroid-Core-Public/format/checksum"
 	"github.com/qcasey/MDroid-Core-Public/format/response"
 	"github.com/qcasey/MDroid-Core-Public/mserial"
 	"github.com/qcasey/MDroid-Core-Public/sessions"
@@ -35,6 +37,8 @@ var routes []MDroidRoute
 func stopMDroid(w http.ResponseWriter, r *http.Request) {
 	log.Info().Msg("Stopping MDroid Service as per request")
 	response.WriteNew(&w, r, response.JSONResponse{Output: "OK", OK: true})
+	sessions.SaveFile()
+	settings.Flush()
 	os.Exit(0)
 }
 
@@ -58,6 +62,8 @@ func handleSleepMDroid(w http.ResponseWriter, r *http.Request) {
 
 func sleepMDroid() {
 	log.Info().Msg("Going to sleep now! Powering down.")
+	sessions.SaveFile()
+	settings.Flush()
 	go func() { mserial.PushText(fmt.Sprintf("putToSleep%d", -1)) }()
 	sendServiceCommand("MDROID", "shutdown")
 }
@@ -156,6 +162,15 @@ func changeLogLevel(level zerolog.Level) {
 	zerolog.SetGlobalLevel(level)
 }
 
+// List every session and settings hook, and how they've been running
+func handleGetHooks(w http.ResponseWriter, r *http.Request) {
+	hooks := map[string]interface{}{
+		"session":  sessions.GetHooks(),
+		"settings": settings.GetHooks(),
+	}
+	response.WriteNew(&w, r, response.JSONResponse{Output: hooks, OK: true})
+}
+
 // **
 // end router functions
 // **
@@ -179,25 +194,40 @@ func SetDefaultRoutes(router *mux.Router) {
 	router.HandleFunc("/shutdown", handleSleepMDroid).Methods("GET")
 	router.HandleFunc("/alert/{message}", handleSlackAlert).Methods("GET")
 	router.HandleFunc("/responses/stats", response.HandleGetStats).Methods("GET")
+	router.HandleFunc("/hooks", handleGetHooks).Methods("GET")
+	router.HandleFunc("/events", events.HandleStream).Methods("GET")
 	router.HandleFunc("/debug/level/{level}", handleChangeLogLevel).Methods("GET")
 
 	//
 	// Session routes
 	//
 	router.HandleFunc("/session", sessions.HandleGetAll).Methods("GET")
+	router.HandleFunc("/session", checksum.Required(sessions.HandleSetBatch)).Methods("POST")
 	router.HandleFunc("/session/stats", sessions.HandleGetStats).Methods("GET")
+	router.HandleFunc("/session/stats/{name}", sessions.HandleGetKeyStats).Methods("GET")
+	router.HandleFunc("/session/stream", sessions.HandleStream).Methods("GET")
+	router.HandleFunc("/session/derived", sessions.HandleGetDerived).Methods("GET")
+	router.HandleFunc("/session/schema", sessions.HandleGetSchema).Methods("GET")
 	router.HandleFunc("/session/{name}", sessions.HandleGet).Methods("GET")
-	router.HandleFunc("/session/{name}/{checksum}", sessions.HandleSet).Methods("POST")
-	router.HandleFunc("/session/{name}", sessions.HandleSet).Methods("POST")
+	router.HandleFunc("/session/{name}/history", sessions.HandleGetHistory).Methods("GET")
+	router.HandleFunc("/session/{name}/{checksum}", checksum.Handler(sessions.HandleSet)).Methods("POST")
+	router.HandleFunc("/session/{name}", checksum.Required(sessions.HandleSet)).Methods("POST")
 
 	//
 	// Settings routes
 	//
 	router.HandleFunc("/settings", settings.HandleGetAll).Methods("GET")
+	router.HandleFunc("/settings/schema", settings.HandleGetSchema).Methods("GET")
+	router.HandleFunc("/settings/history", settings.HandleGetHistory).Methods("GET")
+	router.HandleFunc("/settings/rollback/{id}", settings.HandleRollback).Methods("POST")
+	router.HandleFunc("/settings/profiles", settings.HandleGetProfiles).Methods("GET")
+	router.HandleFunc("/settings/profiles/{name}", settings.HandleActivateProfile).Methods("POST")
 	router.HandleFunc("/settings/{component}", settings.HandleGet).Methods("GET")
+	router.HandleFunc("/settings/{component}", settings.HandleDeleteComponent).Methods("DELETE")
 	router.HandleFunc("/settings/{component}/{name}", settings.HandleGetValue).Methods("GET")
-	router.HandleFunc("/settings/{component}/{name}/{value}/{checksum}", settings.HandleSet).Methods("POST")
-	router.HandleFunc("/settings/{component}/{name}/{value}", settings.HandleSet).Methods("POST")
+	router.HandleFunc("/settings/{component}/{name}", settings.HandleDelete).Methods("DELETE")
+	router.HandleFunc("/settings/{component}/{name}/{value}/{checksum}", checksum.Handler(settings.HandleSet)).Methods("POST")
+	router.HandleFunc("/settings/{component}/{name}/{value}", checksum.Required(settings.HandleSet)).Methods("POST")
 
 	//
 	// GraphQL Implementation
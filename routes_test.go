@@ -4,9 +4,14 @@ import (
 	"encoding/json"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
 
+	"github.com/gorilla/mux"
+	"github.com/qcasey/MDroid-Core-Public/format/checksum"
 	"github.com/qcasey/MDroid-Core-Public/format/response"
+	"github.com/qcasey/MDroid-Core-Public/mserial"
+	"github.com/qcasey/MDroid-Core-Public/pybus"
 )
 
 func TestSlackAlert(t *testing.T) {
@@ -41,3 +46,39 @@ func TestSlackAlert(t *testing.T) {
 			resp.OK, expectedResponse.OK)
 	}
 }
+
+func TestChecksumRequired(t *testing.T) {
+	router := mux.NewRouter()
+	SetDefaultRoutes(router)
+	mserial.Mod.SetRoutes(router)
+	pybus.Mod.SetRoutes(router)
+
+	checksum.Setup(&map[string]string{"CHECKSUM_ALGORITHM": "CRC32"})
+	defer checksum.Setup(&map[string]string{})
+
+	// Routes with a {checksum} variant can't be reached without one, whatever the method
+	tables := []struct {
+		method string
+		path   string
+		body   string
+	}{
+		{"GET", "/serial/toggleDoorLocks", ""},
+		{"POST", "/serial/toggleDoorLocks", ""},
+		{"GET", "/pybus/toggleDoorLocks", ""},
+		{"POST", "/session/MAIN_VOLTAGE", `{"value": 12.5}`},
+		{"POST", "/session", `{"MAIN_VOLTAGE": 12.5}`},
+		{"POST", "/settings/MDROID/DEBUG/TRUE", ""},
+	}
+
+	for _, table := range tables {
+		failures := response.Statistics.ChecksumFailures
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, httptest.NewRequest(table.method, table.path, strings.NewReader(table.body)))
+
+		var resp response.JSONResponse
+		json.NewDecoder(rr.Body).Decode(&resp)
+		if resp.OK || response.Statistics.ChecksumFailures != failures+1 {
+			t.Errorf("%s %s without a checksum returned %d %+v; want it rejected", table.method, table.path, rr.Code, resp)
+		}
+	}
+}
@@ -0,0 +1,140 @@
+package sessions
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"sort"
+
+	"github.com/qcasey/MDroid-Core-Public/format/response"
+	"github.com/rs/zerolog/log"
+)
+
+// BatchResult reports the outcome of a single value in a batch of sets
+type BatchResult struct {
+	Name  string `json:"name"`
+	OK    bool   `json:"ok"`
+	Error string `json:"error,omitempty"`
+	Data  *Data  `json:"data,omitempty"`
+}
+
+// SetBatch validates each value and runs its sync hooks, commits all valid values under one lock,
+// and only then starts the other hooks, streams, MQTT and DB writes. Results are in the same order as the batch
+func SetBatch(batch []Data) []BatchResult {
+	results := make([]BatchResult, len(batch))
+	prepared := make([]Data, len(batch))
+
+	for i, newPackage := range batch {
+		results[i].Name = newPackage.Name
+		p, err := prepare(newPackage)
+		if err == nil {
+			err = runSyncHooks(p)
+		}
+		if err != nil {
+			results[i].Error = err.Error()
+			continue
+		}
+		prepared[i] = p
+		results[i].Name = p.Name
+		results[i].OK = true
+	}
+
+	type committed struct {
+		oldPackage Data
+		exists     bool
+	}
+	replaced := make([]committed, len(batch))
+
+	session.Mutex.Lock()
+	for i := range prepared {
+		if results[i].OK {
+			replaced[i].oldPackage, replaced[i].exists = commit(&prepared[i])
+		}
+	}
+	session.Mutex.Unlock()
+
+	for i := range prepared {
+		if !results[i].OK {
+			continue
+		}
+		d := prepared[i]
+		results[i].Data = &d
+		if err := finish(d, replaced[i].oldPackage, replaced[i].exists); err != nil {
+			results[i].OK = false
+			results[i].Error = err.Error()
+		}
+	}
+
+	return results
+}
+
+// parseBatch reads either a map of names to values, or a list of session values
+func parseBatch(body []byte) ([]Data, error) {
+	body = bytes.TrimSpace(body)
+	if len(body) == 0 {
+		return nil, fmt.Errorf("Error: Empty body")
+	}
+
+	// Keep numbers as json.Number, so ints and floats can be told apart
+	decoder := json.NewDecoder(bytes.NewReader(body))
+	decoder.UseNumber()
+
+	var batch []Data
+	switch body[0] {
+	case '[':
+		if err := decoder.Decode(&batch); err != nil {
+			return nil, err
+		}
+	case '{':
+		var values map[string]interface{}
+		if err := decoder.Decode(&values); err != nil {
+			return nil, err
+		}
+
+		// Keep the batch in a predictable order
+		names := make([]string, 0, len(values))
+		for name := range values {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			batch = append(batch, Data{Name: name, Value: values[name]})
+		}
+	default:
+		return nil, fmt.Errorf("Expected a map of names to values, or a list of session values")
+	}
+	return batch, nil
+}
+
+// HandleSetBatch sets many session values in one request.
+// The body is either a map of names to values, or a list of session values with optional types
+func HandleSetBatch(w http.ResponseWriter, r *http.Request) {
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		log.Error().Msgf("Error reading body: %v", err)
+		http.Error(w, "can't read body", http.StatusBadRequest)
+		return
+	}
+
+	batch, err := parseBatch(body)
+	if err != nil {
+		log.Error().Msgf("Error decoding incoming JSON:\n%s", err.Error())
+		response.WriteNew(&w, r, response.JSONResponse{Output: err.Error(), OK: false})
+		return
+	}
+
+	source := requestSource(r)
+	for i := range batch {
+		batch[i].Source = source
+	}
+	results := SetBatch(batch)
+
+	// Only OK if every value was set
+	ok := true
+	for _, result := range results {
+		ok = ok && result.OK
+	}
+	response.WriteNew(&w, r, response.JSONResponse{Output: results, OK: ok})
+}
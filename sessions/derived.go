@@ -0,0 +1,314 @@
+package sessions
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/qcasey/MDroid-Core-Public/format"
+	"github.com/qcasey/MDroid-Core-Public/format/response"
+	"github.com/qcasey/MDroid-Core-Public/settings"
+	"github.com/rs/zerolog/log"
+)
+
+// derivedComponent is the settings component declaring derived session values.
+// Each setting is an output key, and its value a rule:
+//
+//	LINEAR;SOURCE;SCALE;OFFSET              output = source * scale + offset
+//	PIECEWISE;SOURCE;X:Y,X:Y,...            interpolated calibration table, repeating an X makes a step
+//	                                        that takes its second Y at X, and continues from its last above it
+//	EXPRESSION;SOURCE_A*SOURCE_B+1          numbers, session names, + - * / ( ) ABS MIN MAX
+//
+// Settings can't hold spaces, so expressions are written without them
+const derivedComponent = "DERIVED"
+
+// defaultDerived replaces the conversions that used to be hard coded as hooks
+var defaultDerived = map[string]string{
+	"MAIN_VOLTAGE": "LINEAR;MAIN_VOLTAGE_RAW;0.01611328125;0",
+	"AUX_VOLTAGE":  "LINEAR;AUX_VOLTAGE_RAW;0.01611328125;0",
+	"AUX_CURRENT":  "PIECEWISE;AUX_CURRENT_RAW;0:0.09,0.3:0.39,0.3:0.36,1.5:1.56,1.5:1.56,1.5:1.8,2.5:2.8",
+}
+
+// DerivedRule computes an output session value from one or more source values
+type DerivedRule struct {
+	Output    string   `json:"output"`
+	Rule      string   `json:"rule"`
+	Transform string   `json:"transform"`
+	Sources   []string `json:"sources"`
+	Runs      int      `json:"runs"`
+	Errors    int      `json:"errors"`
+	LastValue float64  `json:"lastValue"`
+	LastRun   string   `json:"lastRun,omitempty"`
+	LastError string   `json:"lastError,omitempty"`
+	transform expression
+}
+
+type calibrationPoint struct {
+	x, y float64
+}
+
+// piecewise interpolates a calibration table, extrapolating past either end
+type piecewise struct {
+	source string
+	points []calibrationPoint
+}
+
+var derived = struct {
+	rules map[string]*DerivedRule
+	mutex sync.Mutex
+}{rules: make(map[string]*DerivedRule)}
+
+func (p piecewise) eval(lookup func(string) (float64, error)) (float64, error) {
+	x, err := lookup(p.source)
+	if err != nil {
+		return 0, err
+	}
+
+	// Values on a step take its second Y, so a step can be closed on either side
+	last := len(p.points) - 2
+	for i := 0; i <= last; i++ {
+		if p.points[i].x == x && p.points[i+1].x == x {
+			return p.points[i+1].y, nil
+		}
+	}
+
+	// Find the first segment x falls before the end of, skipping steps
+	segment := last
+	for i := 0; i <= last; i++ {
+		if p.points[i].x != p.points[i+1].x && x < p.points[i+1].x {
+			segment = i
+			break
+		}
+	}
+	for segment > 0 && p.points[segment].x == p.points[segment+1].x {
+		segment--
+	}
+
+	a, b := p.points[segment], p.points[segment+1]
+	if a.x == b.x {
+		return a.y, nil
+	}
+	return a.y + (x-a.x)*(b.y-a.y)/(b.x-a.x), nil
+}
+
+// parseDerivedRule compiles a rule from its setting value
+func parseDerivedRule(output string, rule string) (*DerivedRule, error) {
+	fields := strings.Split(rule, ";")
+	d := &DerivedRule{Output: format.Name(output), Rule: rule, Transform: strings.ToUpper(fields[0])}
+
+	switch d.Transform {
+	case "LINEAR":
+		if len(fields) != 4 {
+			return nil, fmt.Errorf("LINEAR expects LINEAR;SOURCE;SCALE;OFFSET")
+		}
+		scale, err := strconv.ParseFloat(fields[2], 64)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid scale %s", fields[2])
+		}
+		offset, err := strconv.ParseFloat(fields[3], 64)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid offset %s", fields[3])
+		}
+		source := format.Name(fields[1])
+		d.Sources = []string{source}
+		d.transform = binaryNode{operator: '+', left: binaryNode{operator: '*', left: nameNode(source), right: numberNode(scale)}, right: numberNode(offset)}
+	case "PIECEWISE":
+		if len(fields) != 3 {
+			return nil, fmt.Errorf("PIECEWISE expects PIECEWISE;SOURCE;X:Y,X:Y,...")
+		}
+		p := piecewise{source: format.Name(fields[1])}
+		for _, point := range strings.Split(fields[2], ",") {
+			xy := strings.Split(point, ":")
+			if len(xy) != 2 {
+				return nil, fmt.Errorf("Invalid calibration point %s", point)
+			}
+			x, errX := strconv.ParseFloat(xy[0], 64)
+			y, errY := strconv.ParseFloat(xy[1], 64)
+			if errX != nil || errY != nil {
+				return nil, fmt.Errorf("Invalid calibration point %s", point)
+			}
+			if len(p.points) > 0 && x < p.points[len(p.points)-1].x {
+				return nil, fmt.Errorf("Calibration points must be in order, %s is out of place", point)
+			}
+			p.points = append(p.points, calibrationPoint{x: x, y: y})
+		}
+		if len(p.points) < 2 {
+			return nil, fmt.Errorf("PIECEWISE needs at least two calibration points")
+		}
+		d.Sources = []string{p.source}
+		d.transform = p
+	case "EXPRESSION":
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("EXPRESSION expects EXPRESSION;EXPRESSION")
+		}
+		e, names, err := parseExpression(fields[1])
+		if err != nil {
+			return nil, err
+		}
+		if len(names) == 0 {
+			return nil, fmt.Errorf("Expression doesn't use any session values")
+		}
+		d.Sources = names
+		d.transform = e
+	default:
+		return nil, fmt.Errorf("Unknown transform %s, expected LINEAR, PIECEWISE or EXPRESSION", d.Transform)
+	}
+	return d, nil
+}
+
+// createsCycle returns true if setting output would eventually set output again. Derived lock must be held
+func createsCycle(output string) bool {
+	visited := map[string]bool{}
+	queue := []string{output}
+	for len(queue) > 0 {
+		name := queue[0]
+		queue = queue[1:]
+		for _, rule := range derived.rules {
+			if !format.StringInSlice(name, rule.Sources) {
+				continue
+			}
+			if rule.Output == output {
+				return true
+			}
+			if !visited[rule.Output] {
+				visited[rule.Output] = true
+				queue = append(queue, rule.Output)
+			}
+		}
+	}
+	return false
+}
+
+// setDerivedRule adds, replaces or (with an empty rule) removes a derived value
+func setDerivedRule(output string, rule string) error {
+	output = format.Name(output)
+
+	derived.mutex.Lock()
+	defer derived.mutex.Unlock()
+
+	if rule == "" {
+		delete(derived.rules, output)
+		return nil
+	}
+
+	d, err := parseDerivedRule(output, rule)
+	if err != nil {
+		return fmt.Errorf("Invalid derived rule for %s: %s", output, err.Error())
+	}
+
+	old, replacing := derived.rules[output]
+	derived.rules[output] = d
+	if createsCycle(output) {
+		if replacing {
+			derived.rules[output] = old
+		} else {
+			delete(derived.rules, output)
+		}
+		return fmt.Errorf("Invalid derived rule for %s: it depends on itself", output)
+	}
+	return nil
+}
+
+// setupDerived loads derived values from settings, declaring the defaults in the settings schema
+func setupDerived() {
+	schema := make(map[string]settings.Schema, len(defaultDerived))
+	for output, rule := range defaultDerived {
+		schema[output] = settings.Schema{Default: rule, Description: "Derived session value"}
+	}
+	if err := settings.RegisterSchema(derivedComponent, schema); err != nil {
+		log.Error().Msg(err.Error())
+	}
+
+	rules, err := settings.GetComponent(derivedComponent)
+	if err != nil {
+		log.Error().Msg(err.Error())
+	}
+
+	for output, rule := range rules {
+		if err := setDerivedRule(output, rule); err != nil {
+			log.Error().Msg(err.Error())
+		}
+	}
+
+	// Reject invalid rules before they're saved, and stop deriving values once their rule is deleted
+	settings.RegisterHookWithOptions(derivedComponent, settings.HookOptions{Sync: true}, setDerivedRule)
+	settings.RegisterDeleteHook(derivedComponent, settings.HookOptions{Sync: true}, deleteDerivedRule)
+}
+
+// deleteDerivedRule stops deriving a value
+func deleteDerivedRule(output string) error {
+	return setDerivedRule(output, "")
+}
+
+// runDerived sets every derived value depending on the new value
+func runDerived(trigger Data) {
+	type result struct {
+		output string
+		value  float64
+	}
+	var results []result
+
+	derived.mutex.Lock()
+	for _, rule := range derived.rules {
+		if !format.StringInSlice(trigger.Name, rule.Sources) {
+			continue
+		}
+
+		value, err := rule.transform.eval(func(name string) (float64, error) {
+			if name == trigger.Name {
+				return trigger.Float()
+			}
+			return GetFloat(name)
+		})
+
+		rule.Runs++
+		rule.LastRun = trigger.LastUpdate
+		if err == nil && (math.IsNaN(value) || math.IsInf(value, 0)) {
+			err = fmt.Errorf("Result is not a number")
+		}
+		if err != nil {
+			rule.Errors++
+			rule.LastError = err.Error()
+			log.Debug().Msgf("Derived value %s failed: %s", rule.Output, err.Error())
+			continue
+		}
+
+		value = roundDerived(value)
+		rule.LastValue = value
+		rule.LastError = ""
+		results = append(results, result{output: rule.Output, value: value})
+	}
+	derived.mutex.Unlock()
+
+	for _, r := range results {
+		SetValueFrom(r.output, r.value, SourceDerived)
+	}
+}
+
+// roundDerived keeps the same precision (and rounding) the hard coded conversions used
+func roundDerived(value float64) float64 {
+	rounded, _ := strconv.ParseFloat(strconv.FormatFloat(value, 'f', 3, 64), 64)
+	return rounded
+}
+
+// GetDerived returns all derived value rules, with how they've been running
+func GetDerived() []DerivedRule {
+	derived.mutex.Lock()
+	defer derived.mutex.Unlock()
+
+	var output []DerivedRule
+	for _, rule := range derived.rules {
+		output = append(output, *rule)
+	}
+	sort.Slice(output, func(i, j int) bool { return output[i].Output < output[j].Output })
+	return output
+}
+
+// HandleGetDerived returns all derived value rules, and their last errors
+func HandleGetDerived(w http.ResponseWriter, r *http.Request) {
+	response.WriteNew(&w, r, response.JSONResponse{Output: GetDerived(), OK: true})
+}
@@ -0,0 +1,112 @@
+package sessions
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"testing"
+)
+
+func TestDerivedRule(t *testing.T) {
+	values := map[string]float64{"MAIN_VOLTAGE_RAW": 768, "AUX_CURRENT_RAW": 1, "AUX_VOLTAGE": 12.5, "AUX_CURRENT": 2}
+	lookup := func(name string) (float64, error) {
+		if v, ok := values[name]; ok {
+			return v, nil
+		}
+		return 0, fmt.Errorf("%s does not exist in Session", name)
+	}
+
+	tables := []struct {
+		rule   string
+		output float64
+		err    bool
+	}{
+		{"LINEAR;MAIN_VOLTAGE_RAW;0.01611328125;0", 12.375, false},
+		{"LINEAR;MAIN_VOLTAGE_RAW;2;-1", 1535, false},
+		{defaultDerived["AUX_CURRENT"], 1.06, false},
+		{"PIECEWISE;AUX_CURRENT_RAW;0:0,2:10", 5, false},
+		{"PIECEWISE;AUX_CURRENT_RAW;2:0,4:10", -5, false},
+		{"EXPRESSION;AUX_VOLTAGE*AUX_CURRENT", 25, false},
+		{"EXPRESSION;AUX_VOLTAGE_*_(AUX_CURRENT_-_1)", 12.5, false},
+		{"EXPRESSION;MAX(AUX_CURRENT,3)/-2", -1.5, false},
+		{"EXPRESSION;AUX_VOLTAGE/(AUX_CURRENT-2)", 0, true},
+		{"EXPRESSION;MISSING_VALUE+1", 0, true},
+	}
+
+	for _, table := range tables {
+		d, err := parseDerivedRule("OUTPUT", table.rule)
+		if err != nil {
+			t.Errorf("parseDerivedRule(%s) returned error %s", table.rule, err.Error())
+			continue
+		}
+		got, err := d.transform.eval(lookup)
+		if (err != nil) != table.err {
+			t.Errorf("%s returned error %v; want error %v", table.rule, err, table.err)
+			continue
+		}
+		if !table.err && math.Abs(got-table.output) > 1e-9 {
+			t.Errorf("%s = %f; want %f", table.rule, got, table.output)
+		}
+	}
+
+	for _, rule := range []string{"LINEAR;A;B;0", "PIECEWISE;A;2:0,1:1", "EXPRESSION;A+", "EXPRESSION;MIN(A)", "SQUARE;A"} {
+		if _, err := parseDerivedRule("OUTPUT", rule); err == nil {
+			t.Errorf("parseDerivedRule(%s) should have failed", rule)
+		}
+	}
+}
+
+// The hooks the default derived values replaced
+func oldVoltage(raw float64) float64 {
+	v, _ := strconv.ParseFloat(fmt.Sprintf("%.3f", (raw/1024)*16.5), 64)
+	return v
+}
+
+func oldAuxCurrent(raw float64) float64 {
+	modifier := .06
+	if raw < .3 {
+		modifier = .09
+	} else if raw > 1.5 {
+		modifier = .3
+	}
+	v, _ := strconv.ParseFloat(fmt.Sprintf("%.3f", raw+modifier), 64)
+	return v
+}
+
+func TestDefaultDerived(t *testing.T) {
+	tables := []struct {
+		output string
+		source string
+		old    func(float64) float64
+		raws   []float64
+	}{
+		{"MAIN_VOLTAGE", "MAIN_VOLTAGE_RAW", oldVoltage, nil},
+		{"AUX_VOLTAGE", "AUX_VOLTAGE_RAW", oldVoltage, nil},
+		{"AUX_CURRENT", "AUX_CURRENT_RAW", oldAuxCurrent, []float64{0, 0.1, 0.29, 0.3, 0.31, 0.8, 1, 1.49, 1.5, 1.51, 2, 2.5, 3.2}},
+	}
+
+	for _, table := range tables {
+		// Voltages are read from a 10 bit ADC
+		if table.raws == nil {
+			for raw := 0; raw < 1024; raw++ {
+				table.raws = append(table.raws, float64(raw))
+			}
+		}
+
+		d, err := parseDerivedRule(table.output, defaultDerived[table.output])
+		if err != nil {
+			t.Errorf("Default rule for %s returned error %s", table.output, err.Error())
+			continue
+		}
+		for _, raw := range table.raws {
+			got, err := d.transform.eval(func(string) (float64, error) { return raw, nil })
+			if err != nil {
+				t.Errorf("%s(%v) returned error %s", table.output, raw, err.Error())
+				continue
+			}
+			if want := table.old(raw); roundDerived(got) != want {
+				t.Errorf("%s(%v) = %v; the old hook set %v", table.output, raw, roundDerived(got), want)
+			}
+		}
+	}
+}
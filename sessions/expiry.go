@@ -0,0 +1,130 @@
+package sessions
+
+import (
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// maxAge is how long a session value stays current before it's stale, and optionally dropped
+type maxAge struct {
+	age  time.Duration
+	drop bool
+}
+
+// setupExpiry reads max age rules from the MDROID config, in seconds.
+// Rules are per key or per key prefix, and can drop the value once expired, i.e.
+// MAX_AGE_ACC_POWER=300 or MAX_AGE_TABLET_*=60,DROP
+func setupExpiry(configMap map[string]string) {
+	session.Mutex.Lock()
+	defer session.Mutex.Unlock()
+
+	for key, value := range configMap {
+		name := strings.TrimPrefix(key, "MAX_AGE_")
+		if name == key || name == "" {
+			continue
+		}
+
+		parts := strings.Split(value, ",")
+		seconds, err := strconv.Atoi(strings.TrimSpace(parts[0]))
+		if err != nil || seconds <= 0 {
+			log.Error().Msgf("Invalid max age %s for %s", value, key)
+			continue
+		}
+
+		rule := maxAge{age: time.Duration(seconds) * time.Second}
+		if len(parts) > 1 && strings.TrimSpace(parts[1]) == "DROP" {
+			rule.drop = true
+		}
+		session.maxAges[name] = rule
+	}
+
+	if len(session.maxAges) == 0 {
+		return
+	}
+
+	log.Info().Msgf("Checking %d session max age rules", len(session.maxAges))
+	go func() {
+		for {
+			time.Sleep(time.Second)
+			expireValues()
+		}
+	}()
+}
+
+// getMaxAge finds the rule for a session key, preferring an exact match over the longest prefix.
+// Session must be locked
+func getMaxAge(name string) (maxAge, bool) {
+	if rule, ok := session.maxAges[name]; ok {
+		return rule, true
+	}
+
+	var match maxAge
+	matchLength := -1
+	for pattern, rule := range session.maxAges {
+		prefix := strings.TrimSuffix(pattern, "*")
+		if prefix != pattern && strings.HasPrefix(name, prefix) && len(prefix) > matchLength {
+			match = rule
+			matchLength = len(prefix)
+		}
+	}
+	return match, matchLength >= 0
+}
+
+// expireValues flags session values past their max age as stale, dropping them if required.
+// Hooks registered with OnExpire are then run with the expired value
+func expireValues() {
+	var expired []Data
+	now := time.Now()
+
+	session.Mutex.Lock()
+	for name, d := range session.data {
+		rule, ok := getMaxAge(name)
+		if !ok || now.Sub(d.date) < rule.age {
+			continue
+		}
+
+		if rule.drop {
+			delete(session.data, name)
+			session.dropped[name] = now
+		} else if !d.Stale {
+			d.Stale = true
+			session.data[name] = d
+		} else {
+			// Already flagged
+			continue
+		}
+		d.Stale = true
+		expired = append(expired, d)
+	}
+	session.Mutex.Unlock()
+
+	for _, d := range expired {
+		log.Debug().Msgf("%s has expired, last updated %s", d.Name, d.LastUpdate)
+		go runExpiredHooks(d)
+	}
+}
+
+// IsExpired returns if the named session exists, but is past its max age, or was dropped once it was.
+// Unlike IsStale, values restored from the session file are only expired once they're too old
+func IsExpired(name string) bool {
+	session.Mutex.RLock()
+	defer session.Mutex.RUnlock()
+	d, ok := session.data[name]
+	if !ok {
+		_, dropped := session.dropped[name]
+		return dropped
+	}
+	rule, ok := getMaxAge(name)
+	return ok && time.Since(d.date) >= rule.age
+}
+
+// IsStale returns if the named session exists, but is stale
+func IsStale(name string) bool {
+	session.Mutex.RLock()
+	defer session.Mutex.RUnlock()
+	d, ok := session.data[name]
+	return ok && d.Stale
+}
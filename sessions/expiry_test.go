@@ -0,0 +1,53 @@
+package sessions
+
+import (
+	"testing"
+	"time"
+)
+
+func TestIsExpired(t *testing.T) {
+	setupExpiry(map[string]string{"MAX_AGE_EXPIRY_TEST_*": "60", "MAX_AGE_EXPIRY_DROP_TEST": "60,DROP"})
+
+	now := time.Now()
+	tables := []struct {
+		data    Data
+		expired bool
+	}{
+		{Data{Name: "EXPIRY_TEST_FRESH", date: now}, false},
+		{Data{Name: "EXPIRY_TEST_OLD", date: now.Add(-2 * time.Minute)}, true},
+		{Data{Name: "EXPIRY_TEST_RESTORED", date: now.Add(-30 * time.Second), Restored: true, Stale: true}, false},
+		{Data{Name: "EXPIRY_TEST_RESTORED_OLD", date: now.Add(-2 * time.Minute), Restored: true, Stale: true}, true},
+		{Data{Name: "NO_MAX_AGE", date: now.Add(-time.Hour)}, false},
+	}
+
+	session.Mutex.Lock()
+	for _, table := range tables {
+		session.data[table.data.Name] = table.data
+	}
+	session.Mutex.Unlock()
+
+	for _, table := range tables {
+		if expired := IsExpired(table.data.Name); expired != table.expired {
+			t.Errorf("IsExpired(%s) = %v; want %v", table.data.Name, expired, table.expired)
+		}
+	}
+	if IsExpired("EXPIRY_TEST_MISSING") {
+		t.Errorf("IsExpired of a missing value should be false")
+	}
+
+	// Dropped values stay expired until they're set again
+	session.Mutex.Lock()
+	session.data["EXPIRY_DROP_TEST"] = Data{Name: "EXPIRY_DROP_TEST", date: now.Add(-2 * time.Minute)}
+	session.Mutex.Unlock()
+	expireValues()
+	if _, err := Get("EXPIRY_DROP_TEST"); err == nil {
+		t.Errorf("EXPIRY_DROP_TEST should have been dropped")
+	}
+	if !IsExpired("EXPIRY_DROP_TEST") {
+		t.Errorf("IsExpired of a dropped value should be true")
+	}
+	SetValue("EXPIRY_DROP_TEST", 1)
+	if IsExpired("EXPIRY_DROP_TEST") {
+		t.Errorf("IsExpired of a dropped value set again should be false")
+	}
+}
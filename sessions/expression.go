@@ -0,0 +1,265 @@
+package sessions
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// expression is a compiled arithmetic expression over session values, i.e. AUX_VOLTAGE*AUX_CURRENT
+type expression interface {
+	eval(lookup func(name string) (float64, error)) (float64, error)
+}
+
+type numberNode float64
+
+type nameNode string
+
+type negateNode struct {
+	operand expression
+}
+
+type binaryNode struct {
+	operator    byte
+	left, right expression
+}
+
+type callNode struct {
+	function string
+	args     []expression
+}
+
+func (n numberNode) eval(lookup func(string) (float64, error)) (float64, error) {
+	return float64(n), nil
+}
+
+func (n nameNode) eval(lookup func(string) (float64, error)) (float64, error) {
+	return lookup(string(n))
+}
+
+func (n negateNode) eval(lookup func(string) (float64, error)) (float64, error) {
+	v, err := n.operand.eval(lookup)
+	return -v, err
+}
+
+func (n binaryNode) eval(lookup func(string) (float64, error)) (float64, error) {
+	left, err := n.left.eval(lookup)
+	if err != nil {
+		return 0, err
+	}
+	right, err := n.right.eval(lookup)
+	if err != nil {
+		return 0, err
+	}
+
+	switch n.operator {
+	case '+':
+		return left + right, nil
+	case '-':
+		return left - right, nil
+	case '*':
+		return left * right, nil
+	}
+	if right == 0 {
+		return 0, fmt.Errorf("Division by zero")
+	}
+	return left / right, nil
+}
+
+func (n callNode) eval(lookup func(string) (float64, error)) (float64, error) {
+	args := make([]float64, len(n.args))
+	for i, arg := range n.args {
+		v, err := arg.eval(lookup)
+		if err != nil {
+			return 0, err
+		}
+		args[i] = v
+	}
+
+	switch n.function {
+	case "ABS":
+		return math.Abs(args[0]), nil
+	case "MIN":
+		return math.Min(args[0], args[1]), nil
+	}
+	return math.Max(args[0], args[1]), nil
+}
+
+// functionArgs are the functions usable in expressions, and how many arguments they take
+var functionArgs = map[string]int{"ABS": 1, "MIN": 2, "MAX": 2}
+
+type expressionParser struct {
+	tokens []string
+	pos    int
+	names  []string
+}
+
+// parseExpression compiles an expression of numbers, session names, + - * / and parentheses,
+// and ABS, MIN and MAX. Returns the session names the expression depends on
+func parseExpression(input string) (expression, []string, error) {
+	tokens, err := tokenize(input)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(tokens) == 0 {
+		return nil, nil, fmt.Errorf("Empty expression")
+	}
+
+	p := &expressionParser{tokens: tokens}
+	e, err := p.parseSum()
+	if err != nil {
+		return nil, nil, err
+	}
+	if p.pos < len(p.tokens) {
+		return nil, nil, fmt.Errorf("Unexpected %s in expression", p.tokens[p.pos])
+	}
+	return e, p.names, nil
+}
+
+// tokenize splits an expression into numbers, names and operators.
+// Settings replace spaces with underscores, so underscores around operators are ignored
+func tokenize(input string) ([]string, error) {
+	var tokens []string
+	runes := []rune(input)
+	for i := 0; i < len(runes); {
+		r := runes[i]
+		switch {
+		case unicode.IsSpace(r):
+			i++
+		case strings.ContainsRune("+-*/(),", r):
+			tokens = append(tokens, string(r))
+			i++
+		case r == '.' || unicode.IsDigit(r):
+			start := i
+			for i < len(runes) && (runes[i] == '.' || unicode.IsDigit(runes[i])) {
+				i++
+			}
+			tokens = append(tokens, string(runes[start:i]))
+		case r == '_' || unicode.IsLetter(r):
+			start := i
+			for i < len(runes) && (runes[i] == '_' || unicode.IsLetter(runes[i]) || unicode.IsDigit(runes[i])) {
+				i++
+			}
+			if name := strings.Trim(string(runes[start:i]), "_"); name != "" {
+				tokens = append(tokens, strings.ToUpper(name))
+			}
+		default:
+			return nil, fmt.Errorf("Unexpected %c in expression", r)
+		}
+	}
+	return tokens, nil
+}
+
+func (p *expressionParser) peek() string {
+	if p.pos < len(p.tokens) {
+		return p.tokens[p.pos]
+	}
+	return ""
+}
+
+func (p *expressionParser) next() string {
+	token := p.peek()
+	p.pos++
+	return token
+}
+
+func (p *expressionParser) parseSum() (expression, error) {
+	left, err := p.parseProduct()
+	if err != nil {
+		return nil, err
+	}
+	for p.peek() == "+" || p.peek() == "-" {
+		operator := p.next()[0]
+		right, err := p.parseProduct()
+		if err != nil {
+			return nil, err
+		}
+		left = binaryNode{operator: operator, left: left, right: right}
+	}
+	return left, nil
+}
+
+func (p *expressionParser) parseProduct() (expression, error) {
+	left, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	for p.peek() == "*" || p.peek() == "/" {
+		operator := p.next()[0]
+		right, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		left = binaryNode{operator: operator, left: left, right: right}
+	}
+	return left, nil
+}
+
+func (p *expressionParser) parseUnary() (expression, error) {
+	if p.peek() == "-" {
+		p.next()
+		operand, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return negateNode{operand: operand}, nil
+	}
+	return p.parsePrimary()
+}
+
+func (p *expressionParser) parsePrimary() (expression, error) {
+	token := p.next()
+	switch {
+	case token == "":
+		return nil, fmt.Errorf("Unexpected end of expression")
+	case token == "(":
+		e, err := p.parseSum()
+		if err != nil {
+			return nil, err
+		}
+		if p.next() != ")" {
+			return nil, fmt.Errorf("Missing ) in expression")
+		}
+		return e, nil
+	case token[0] == '.' || unicode.IsDigit(rune(token[0])):
+		v, err := strconv.ParseFloat(token, 64)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid number %s in expression", token)
+		}
+		return numberNode(v), nil
+	case unicode.IsLetter(rune(token[0])):
+		if argCount, isFunction := functionArgs[token]; isFunction && p.peek() == "(" {
+			return p.parseCall(token, argCount)
+		}
+		p.names = append(p.names, token)
+		return nameNode(token), nil
+	}
+	return nil, fmt.Errorf("Unexpected %s in expression", token)
+}
+
+func (p *expressionParser) parseCall(function string, argCount int) (expression, error) {
+	p.next() // (
+	call := callNode{function: function}
+	for {
+		arg, err := p.parseSum()
+		if err != nil {
+			return nil, err
+		}
+		call.args = append(call.args, arg)
+
+		token := p.next()
+		if token == ")" {
+			break
+		}
+		if token != "," {
+			return nil, fmt.Errorf("Missing ) after %s arguments", function)
+		}
+	}
+
+	if len(call.args) != argCount {
+		return nil, fmt.Errorf("%s takes %d arguments, got %d", function, argCount, len(call.args))
+	}
+	return call, nil
+}
@@ -0,0 +1,150 @@
+package sessions
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// defaultSaveInterval is used when SESSION_SAVE_INTERVAL is not set in the MDROID config
+const defaultSaveInterval = 60 * time.Second
+
+// savedData is a session value as written to the session file, with its exact update time
+type savedData struct {
+	Data
+	Date time.Time `json:"date"`
+}
+
+// setupFile restores the session from SESSION_FILE, and then periodically snapshots it back.
+// Values restored are marked as both restored and stale
+func setupFile(configMap map[string]string) {
+	file, ok := configMap["SESSION_FILE"]
+	if !ok || file == "" {
+		log.Info().Msg("SESSION_FILE not set, session will not persist across restarts")
+		return
+	}
+
+	interval := defaultSaveInterval
+	if intervalString, ok := configMap["SESSION_SAVE_INTERVAL"]; ok {
+		seconds, err := strconv.Atoi(intervalString)
+		if err != nil || seconds <= 0 {
+			log.Error().Msgf("Invalid SESSION_SAVE_INTERVAL %s, defaulting to %s", intervalString, defaultSaveInterval.String())
+		} else {
+			interval = time.Duration(seconds) * time.Second
+		}
+	}
+
+	session.Mutex.Lock()
+	session.file = file
+	session.Mutex.Unlock()
+
+	if err := readFile(file); err != nil {
+		log.Warn().Msgf("Could not restore session from '%s': %s", file, err.Error())
+	}
+
+	go func() {
+		for {
+			time.Sleep(interval)
+			SaveFile()
+		}
+	}()
+}
+
+// readFile restores session values from the given file.
+// Values already in the session are newer, and are not overwritten
+func readFile(file string) error {
+	contents, err := ioutil.ReadFile(file)
+	if err != nil {
+		return err
+	}
+
+	var saved map[string]savedData
+	decoder := json.NewDecoder(bytes.NewReader(contents))
+	decoder.UseNumber()
+	if err = decoder.Decode(&saved); err != nil {
+		return err
+	}
+
+	session.Mutex.Lock()
+	defer session.Mutex.Unlock()
+
+	restored := 0
+	for name, s := range saved {
+		if _, exists := session.data[name]; exists {
+			continue
+		}
+
+		value, valueType, err := parseValue(s.Value, s.Type)
+		if err != nil {
+			log.Error().Msgf("Not restoring %s: %s", name, err.Error())
+			continue
+		}
+
+		d := s.Data
+		d.Name = name
+		d.Value = value
+		d.Type = valueType
+		d.date = s.Date
+		d.Restored = true
+		d.Stale = true
+		session.data[name] = d
+		delete(session.dropped, name)
+		restored++
+	}
+
+	log.Info().Msgf("Restored %d session values from '%s'", restored, file)
+	return nil
+}
+
+// SaveFile writes a snapshot of the session to SESSION_FILE, if one is set
+func SaveFile() error {
+	session.Mutex.RLock()
+	file := session.file
+	saved := make(map[string]savedData, len(session.data))
+	for name, d := range session.data {
+		saved[name] = savedData{Data: d, Date: d.date}
+	}
+	session.Mutex.RUnlock()
+
+	if file == "" {
+		return fmt.Errorf("Empty filename")
+	}
+
+	sessionJSON, err := json.Marshal(saved)
+	if err != nil {
+		log.Error().Msg("Failed to marshall Session")
+		return err
+	}
+
+	// Write to a temporary file first, so a power cut can't leave us with half a session
+	tmp, err := ioutil.TempFile(filepath.Dir(file), filepath.Base(file)+".tmp")
+	if err != nil {
+		log.Error().Msg("Failed to write Session to " + file + ": " + err.Error())
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err = tmp.Write(sessionJSON); err == nil {
+		err = tmp.Sync()
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), file)
+	}
+	if err != nil {
+		log.Error().Msg("Failed to write Session to " + file + ": " + err.Error())
+		return err
+	}
+
+	log.Debug().Msgf("Saved %d session values to %s", len(saved), file)
+	return nil
+}
@@ -0,0 +1,78 @@
+package sessions
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestSaveAndRestore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "session")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	session.Mutex.Lock()
+	session.file = filepath.Join(dir, "session.json")
+	session.Mutex.Unlock()
+	defer func() {
+		session.Mutex.Lock()
+		session.file = ""
+		session.Mutex.Unlock()
+	}()
+
+	tables := []struct {
+		name      string
+		values    []interface{} // Set in order, so the last is saved
+		valueType ValueType
+		output    string
+		version   uint64
+	}{
+		{"FILE_TEST_BOOL", []interface{}{"TRUE"}, TypeBool, "TRUE", 1},
+		{"FILE_TEST_INT", []interface{}{"11", "12"}, TypeInt, "12", 2},
+		{"FILE_TEST_FLOAT", []interface{}{12.5}, TypeFloat, "12.5", 1},
+		{"FILE_TEST_STRING", []interface{}{"0123"}, TypeString, "0123", 1},
+		{"FILE_TEST_OBJECT", []interface{}{map[string]interface{}{"a": "b"}}, TypeObject, `{"a":"b"}`, 1},
+		{"FILE_TEST_NEWER", []interface{}{"OLD"}, TypeString, "NEW", 1},
+	}
+
+	for _, table := range tables {
+		for _, value := range table.values {
+			if _, err := Set(Data{Name: table.name, Value: value}); err != nil {
+				t.Fatalf("Set(%s, %v) failed: %s", table.name, value, err.Error())
+			}
+		}
+	}
+	if err := SaveFile(); err != nil {
+		t.Fatalf("SaveFile failed: %s", err.Error())
+	}
+
+	// Values set again after a restart are newer than the snapshot
+	session.Mutex.Lock()
+	for _, table := range tables {
+		delete(session.data, table.name)
+	}
+	session.data["FILE_TEST_NEWER"] = Data{Name: "FILE_TEST_NEWER", Value: "NEW", Type: TypeString, Version: 1}
+	session.Mutex.Unlock()
+
+	if err := readFile(filepath.Join(dir, "session.json")); err != nil {
+		t.Fatalf("readFile failed: %s", err.Error())
+	}
+
+	for _, table := range tables {
+		d, err := Get(table.name)
+		if err != nil {
+			t.Errorf("%s was not restored: %s", table.name, err.Error())
+			continue
+		}
+		if d.ValueString() != table.output || d.Type != table.valueType || d.Version != table.version {
+			t.Errorf("%s restored as %s (%s) version %d; want %s (%s) version %d", table.name, d.ValueString(), d.Type, d.Version, table.output, table.valueType, table.version)
+		}
+		newer := table.name == "FILE_TEST_NEWER"
+		if d.Restored == newer || d.Stale == newer {
+			t.Errorf("%s restored with Restored %v, Stale %v; want both %v", table.name, d.Restored, d.Stale, !newer)
+		}
+	}
+}
@@ -3,21 +3,43 @@ package sessions
 import (
 	"fmt"
 	"net/http"
-	"strconv"
 
 	"github.com/gorilla/mux"
+	"github.com/qcasey/MDroid-Core-Public/format"
 	"github.com/qcasey/MDroid-Core-Public/format/response"
 	"github.com/rs/zerolog/log"
 )
 
-// HandleGetAll responds to an HTTP request for the entire session
+// HandleGetAll responds to an HTTP request for the entire session,
+// or only the values matching the name, prefix and match query parameters
 func HandleGetAll(w http.ResponseWriter, r *http.Request) {
+	filter, err := format.ParseFilter(r.URL.Query())
+	if err != nil {
+		response.WriteNew(&w, r, response.JSONResponse{Output: err.Error(), OK: false})
+		return
+	}
+
 	requestingMin := r.URL.Query().Get("min") == "1"
 	response := response.JSONResponse{OK: true}
+	if filter.IsEmpty() {
+		if requestingMin {
+			response.Output = GetAllMin()
+		} else {
+			response.Output = GetAll()
+		}
+		response.Write(&w, r)
+		return
+	}
+
+	matching := GetMatching(filter)
 	if requestingMin {
-		response.Output = GetAllMin()
+		minData := map[string]interface{}{}
+		for name, d := range matching {
+			minData[name] = d.Value
+		}
+		response.Output = minData
 	} else {
-		response.Output = GetAll()
+		response.Output = matching
 	}
 	response.Write(&w, r)
 }
@@ -37,12 +59,26 @@ func GetAll() map[string]Data {
 	return newData
 }
 
+// GetMatching returns the session values matching a filter, i.e. by prefix (TABLET_) or glob (*_VOLTAGE)
+func GetMatching(filter format.Filter) map[string]Data {
+	newData := map[string]Data{}
+	session.Mutex.RLock()
+	defer session.Mutex.RUnlock()
+	for index, element := range session.data {
+		if filter.Matches(index) {
+			newData[index] = element
+		}
+	}
+
+	return newData
+}
+
 // GetAllMin returns the entire current session, minus unnecc values
-func GetAllMin() map[string]string {
+func GetAllMin() map[string]interface{} {
 	// Log if requested
 	log.Debug().Msg("Responding to request for minimal session")
 
-	newData := map[string]string{}
+	newData := map[string]interface{}{}
 	session.Mutex.RLock()
 	defer session.Mutex.RUnlock()
 	for index, element := range session.data {
@@ -84,12 +120,25 @@ func GetBool(name string) (value bool, err error) {
 	if err != nil {
 		return false, err
 	}
+	return v.Bool()
+}
 
-	vb, err := strconv.ParseBool(v.Value)
+// GetInt returns the named session with an int value, if it exists. 0 otherwise
+func GetInt(name string) (value int64, err error) {
+	v, err := Get(name)
 	if err != nil {
-		return false, err
+		return 0, err
+	}
+	return v.Int()
+}
+
+// GetFloat returns the named session with a float (or int) value, if it exists. 0 otherwise
+func GetFloat(name string) (value float64, err error) {
+	v, err := Get(name)
+	if err != nil {
+		return 0, err
 	}
-	return vb, nil
+	return v.Float()
 }
 
 // GetStringDefault generalizes fetching session string
@@ -97,9 +146,9 @@ func GetStringDefault(name string, def string) string {
 	v, err := Get(name)
 	if err != nil {
 		log.Trace().Msgf("%s could not be determined, defaulting to FALSE", name)
-		v.Value = def
+		return def
 	}
-	return v.Value
+	return v.ValueString()
 }
 
 // GetBoolDefault generalizes fetching session bool
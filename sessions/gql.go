@@ -1,6 +1,117 @@
 package sessions
 
-import "github.com/graphql-go/graphql"
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+
+	"github.com/graphql-go/graphql"
+	"github.com/graphql-go/graphql/language/ast"
+	"github.com/qcasey/MDroid-Core-Public/format"
+)
+
+// valueScalar passes session values through GraphQL with their type intact
+var valueScalar = graphql.NewScalar(
+	graphql.ScalarConfig{
+		Name:        "SessionValue",
+		Description: "A bool, int, float, string or JSON object session value",
+		Serialize: func(value interface{}) interface{} {
+			return value
+		},
+		ParseValue: func(value interface{}) interface{} {
+			return value
+		},
+		ParseLiteral: func(valueAST ast.Value) interface{} {
+			switch valueAST := valueAST.(type) {
+			case *ast.BooleanValue:
+				return valueAST.Value
+			case *ast.IntValue, *ast.FloatValue, *ast.StringValue, *ast.EnumValue:
+				return valueAST.GetValue()
+			}
+			value, err := parseLiteral(valueAST)
+			if err != nil {
+				// GraphQL drops nil arguments without complaint, resolvers return this instead
+				return err
+			}
+			return value
+		},
+	},
+)
+
+// parseLiteral converts an object or list literal into a JSON-like value, keeping numbers as numbers
+func parseLiteral(valueAST ast.Value) (interface{}, error) {
+	switch valueAST := valueAST.(type) {
+	case *ast.BooleanValue:
+		return valueAST.Value, nil
+	case *ast.IntValue:
+		return json.Number(valueAST.Value), nil
+	case *ast.FloatValue:
+		return json.Number(valueAST.Value), nil
+	case *ast.StringValue:
+		return valueAST.Value, nil
+	case *ast.EnumValue:
+		return valueAST.Value, nil
+	case *ast.ObjectValue:
+		object := make(map[string]interface{}, len(valueAST.Fields))
+		for _, field := range valueAST.Fields {
+			value, err := parseLiteral(field.Value)
+			if err != nil {
+				return nil, err
+			}
+			object[field.Name.Value] = value
+		}
+		return object, nil
+	case *ast.ListValue:
+		list := make([]interface{}, 0, len(valueAST.Values))
+		for _, v := range valueAST.Values {
+			value, err := parseLiteral(v)
+			if err != nil {
+				return nil, err
+			}
+			list = append(list, value)
+		}
+		return list, nil
+	}
+	return nil, fmt.Errorf("Cannot use %s as a session value", valueAST.GetKind())
+}
+
+// literalError returns the first argument GraphQL couldn't parse as a session value, if any
+func literalError(values ...interface{}) error {
+	for _, value := range values {
+		if err, ok := value.(error); ok {
+			return err
+		}
+	}
+	return nil
+}
+
+var historyType = graphql.NewObject(
+	graphql.ObjectConfig{
+		Name: "SessionHistory",
+		Fields: graphql.Fields{
+			"value": &graphql.Field{
+				Type:        valueScalar,
+				Description: "Value, with its type intact",
+			},
+			"type": &graphql.Field{
+				Type:        graphql.String,
+				Description: "Type of value (bool, int, float, string or object)",
+			},
+			"lastUpdate": &graphql.Field{
+				Type:        graphql.String,
+				Description: "UTC Time when inserted",
+			},
+			"source": &graphql.Field{
+				Type:        graphql.String,
+				Description: "Where the value came from, i.e. serial, rest or mqtt",
+			},
+			"version": &graphql.Field{
+				Type:        graphql.Int,
+				Description: "Increases with every set, starting at 1",
+			},
+		},
+	},
+)
 
 var sessionType = graphql.NewObject(
 	graphql.ObjectConfig{
@@ -11,13 +122,76 @@ var sessionType = graphql.NewObject(
 				Description: "Value Name",
 			},
 			"value": &graphql.Field{
+				Type:        valueScalar,
+				Description: "Value, with its type intact",
+			},
+			"type": &graphql.Field{
 				Type:        graphql.String,
-				Description: "Value as a string",
+				Description: "Type of value (bool, int, float, string or object)",
 			},
 			"lastUpdate": &graphql.Field{
 				Type:        graphql.String,
 				Description: "UTC Time when inserted",
 			},
+			"restored": &graphql.Field{
+				Type:        graphql.Boolean,
+				Description: "Value was restored from the session file, not set since startup",
+			},
+			"stale": &graphql.Field{
+				Type:        graphql.Boolean,
+				Description: "Value may no longer reflect reality",
+			},
+			"source": &graphql.Field{
+				Type:        graphql.String,
+				Description: "Where the value came from, i.e. serial, rest or mqtt",
+			},
+			"version": &graphql.Field{
+				Type:        graphql.Int,
+				Description: "Increases with every set, starting at 1",
+			},
+			"outOfSpec": &graphql.Field{
+				Type:        graphql.Boolean,
+				Description: "Value is outside of its key's registered spec",
+			},
+			"unit": &graphql.Field{
+				Type:        graphql.String,
+				Description: "Unit of the value, if the key is registered with one",
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					d, ok := p.Source.(Data)
+					if !ok {
+						return nil, nil
+					}
+					info, _ := GetKeyInfo(d.Name)
+					return info.Unit, nil
+				},
+			},
+			"history": &graphql.Field{
+				Type:        graphql.NewList(historyType),
+				Description: "Recent values, oldest first",
+				Args: graphql.FieldConfigArgument{
+					"since": &graphql.ArgumentConfig{
+						Type:        graphql.String,
+						Description: "Duration ago (e.g. 30s) or timestamp to fetch values after",
+					},
+					"limit": &graphql.ArgumentConfig{
+						Type:        graphql.Int,
+						Description: "Maximum number of the most recent values to fetch",
+					},
+				},
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					d, ok := p.Source.(Data)
+					if !ok {
+						return nil, nil
+					}
+					sinceString, _ := p.Args["since"].(string)
+					since, err := parseSince(sinceString)
+					if err != nil {
+						return nil, err
+					}
+					limit, _ := p.Args["limit"].(int)
+					return GetHistory(d.Name, since, limit)
+				},
+			},
 		},
 	},
 )
@@ -31,11 +205,33 @@ var SessionMutation = &graphql.Field{
 			Type: graphql.NewNonNull(graphql.String),
 		},
 		"value": &graphql.ArgumentConfig{
-			Type: graphql.NewNonNull(graphql.String),
+			Type: graphql.NewNonNull(valueScalar),
+		},
+		"type": &graphql.ArgumentConfig{
+			Type:        graphql.String,
+			Description: "Type to store the value as. If not provided, will be inferred from the value",
+		},
+		"ifVersion": &graphql.ArgumentConfig{
+			Type:        graphql.Int,
+			Description: "Only set if the current version matches. 0 means only if not yet set",
+		},
+		"ifValue": &graphql.ArgumentConfig{
+			Type:        valueScalar,
+			Description: "Only set if the current value matches",
 		},
 	},
 	Resolve: func(params graphql.ResolveParams) (interface{}, error) {
-		return SetValue(params.Args["name"].(string), params.Args["value"].(string)), nil
+		valueType, _ := params.Args["type"].(string)
+		var condition Precondition
+		if ifVersion, ok := params.Args["ifVersion"].(int); ok {
+			version := uint64(ifVersion)
+			condition.Version = &version
+		}
+		condition.Value = params.Args["ifValue"]
+		if err := literalError(params.Args["value"], condition.Value); err != nil {
+			return nil, err
+		}
+		return SetIf(Data{Name: params.Args["name"].(string), Value: params.Args["value"], Type: ValueType(valueType), Quiet: true, Source: SourceGraphQL}, condition)
 	},
 }
 
@@ -48,11 +244,33 @@ var SessionQuery = &graphql.Field{
 			Type:        graphql.NewList(graphql.String),
 			Description: "List of names to fetch. If not provided, will get entire session",
 		},
+		"prefix": &graphql.ArgumentConfig{
+			Type:        graphql.NewList(graphql.String),
+			Description: "Fetch names starting with any of these prefixes, i.e. TABLET_",
+		},
+		"match": &graphql.ArgumentConfig{
+			Type:        graphql.NewList(graphql.String),
+			Description: "Fetch names matching any of these globs, i.e. *_VOLTAGE",
+		},
 	},
 	Resolve: func(p graphql.ResolveParams) (interface{}, error) {
 		var outputList []Data
-		names, ok := p.Args["names"].([]string)
-		if ok {
+		names := stringArgs(p.Args["names"])
+		filter := format.Filter{Prefixes: stringArgs(p.Args["prefix"]), Globs: stringArgs(p.Args["match"])}
+		if !filter.IsEmpty() {
+			filter.Names = names
+			filter, err := filter.Normalize()
+			if err != nil {
+				return nil, err
+			}
+			for _, val := range GetMatching(filter) {
+				outputList = append(outputList, val)
+			}
+			sort.Slice(outputList, func(i, j int) bool { return outputList[i].Name < outputList[j].Name })
+			return outputList, nil
+		}
+
+		if len(names) > 0 {
 			for _, name := range names {
 				s, err := Get(name)
 				if err != nil {
@@ -73,3 +291,197 @@ var SessionQuery = &graphql.Field{
 		return outputList, nil
 	},
 }
+
+// stringArgs converts a GraphQL list argument into strings
+func stringArgs(arg interface{}) []string {
+	list, _ := arg.([]interface{})
+	var output []string
+	for _, item := range list {
+		if s, ok := item.(string); ok {
+			output = append(output, s)
+		}
+	}
+	return output
+}
+
+var sessionInputType = graphql.NewInputObject(
+	graphql.InputObjectConfig{
+		Name: "SessionInput",
+		Fields: graphql.InputObjectConfigFieldMap{
+			"name": &graphql.InputObjectFieldConfig{
+				Type: graphql.NewNonNull(graphql.String),
+			},
+			"value": &graphql.InputObjectFieldConfig{
+				Type: graphql.NewNonNull(valueScalar),
+			},
+			"type": &graphql.InputObjectFieldConfig{
+				Type:        graphql.String,
+				Description: "Type to store the value as. If not provided, will be inferred from the value",
+			},
+		},
+	},
+)
+
+var batchResultType = graphql.NewObject(
+	graphql.ObjectConfig{
+		Name: "SessionResult",
+		Fields: graphql.Fields{
+			"name": &graphql.Field{
+				Type:        graphql.String,
+				Description: "Value Name",
+			},
+			"ok": &graphql.Field{
+				Type:        graphql.Boolean,
+				Description: "If the value was set",
+			},
+			"error": &graphql.Field{
+				Type:        graphql.String,
+				Description: "Why the value wasn't set",
+			},
+			"data": &graphql.Field{
+				Type:        sessionType,
+				Description: "Value as stored",
+			},
+		},
+	},
+)
+
+// SessionBatchMutation is a GraphQL schema for setting many session values at once
+var SessionBatchMutation = &graphql.Field{
+	Type:        graphql.NewList(batchResultType),
+	Description: "Post many new session values, committed together",
+	Args: graphql.FieldConfigArgument{
+		"values": &graphql.ArgumentConfig{
+			Type: graphql.NewNonNull(graphql.NewList(graphql.NewNonNull(sessionInputType))),
+		},
+	},
+	Resolve: func(params graphql.ResolveParams) (interface{}, error) {
+		values, _ := params.Args["values"].([]interface{})
+		var batch []Data
+		for _, v := range values {
+			input, ok := v.(map[string]interface{})
+			if !ok {
+				continue
+			}
+			name, _ := input["name"].(string)
+			valueType, _ := input["type"].(string)
+			if err := literalError(input["value"]); err != nil {
+				return nil, fmt.Errorf("%s: %s", name, err.Error())
+			}
+			batch = append(batch, Data{Name: name, Value: input["value"], Type: ValueType(valueType), Quiet: true, Source: SourceGraphQL})
+		}
+		return SetBatch(batch), nil
+	},
+}
+
+var hookType = graphql.NewObject(
+	graphql.ObjectConfig{
+		Name: "SessionHook",
+		Fields: graphql.Fields{
+			"id": &graphql.Field{
+				Type:        graphql.Int,
+				Description: "ID the hook was registered with",
+			},
+			"watching": &graphql.Field{
+				Type:        graphql.String,
+				Description: "Session names the hook is watching",
+			},
+			"function": &graphql.Field{
+				Type:        graphql.String,
+				Description: "Name of the hook function",
+			},
+			"priority": &graphql.Field{
+				Type:        graphql.Int,
+				Description: "Higher priority hooks are run first",
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					return p.Source.(HookInfo).Options.Priority, nil
+				},
+			},
+			"sync": &graphql.Field{
+				Type:        graphql.Boolean,
+				Description: "Hook runs before values are published",
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					return p.Source.(HookInfo).Options.Sync, nil
+				},
+			},
+			"runs": &graphql.Field{
+				Type:        graphql.Int,
+				Description: "Number of times the hook has run",
+			},
+			"failures": &graphql.Field{
+				Type:        graphql.Int,
+				Description: "Number of runs that returned an error or panicked",
+			},
+			"lastRun": &graphql.Field{
+				Type:        graphql.DateTime,
+				Description: "Time the hook last started running",
+			},
+			"lastError": &graphql.Field{
+				Type:        graphql.String,
+				Description: "Most recent error",
+			},
+			"averageDuration": &graphql.Field{
+				Type:        graphql.String,
+				Description: "Average time a run takes",
+			},
+		},
+	},
+)
+
+// HookQuery is a GraphQL schema for listing session hooks
+var HookQuery = &graphql.Field{
+	Type:        graphql.NewList(hookType),
+	Description: "Get registered session hooks, in the order they're run",
+	Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+		return GetHooks(), nil
+	},
+}
+
+var keyInfoType = graphql.NewObject(
+	graphql.ObjectConfig{
+		Name: "SessionKey",
+		Fields: graphql.Fields{
+			"name": &graphql.Field{
+				Type:        graphql.String,
+				Description: "Key, or key prefix ending in *",
+			},
+			"description": &graphql.Field{
+				Type:        graphql.String,
+				Description: "What the key means",
+			},
+			"unit": &graphql.Field{
+				Type:        graphql.String,
+				Description: "Unit of the key's values",
+			},
+			"type": &graphql.Field{
+				Type:        graphql.String,
+				Description: "Type of value (bool, int, float, string or object)",
+			},
+			"min": &graphql.Field{
+				Type:        graphql.Float,
+				Description: "Minimum valid value",
+			},
+			"max": &graphql.Field{
+				Type:        graphql.Float,
+				Description: "Maximum valid value",
+			},
+			"enum": &graphql.Field{
+				Type:        graphql.NewList(graphql.String),
+				Description: "Allowed values",
+			},
+			"onInvalid": &graphql.Field{
+				Type:        graphql.String,
+				Description: "What happens to values out of spec (REJECT or FLAG)",
+			},
+		},
+	},
+)
+
+// SchemaQuery is a GraphQL schema for listing known session keys
+var SchemaQuery = &graphql.Field{
+	Type:        graphql.NewList(keyInfoType),
+	Description: "Get known session keys, with their units and valid values",
+	Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+		return GetSchema(), nil
+	},
+}
@@ -0,0 +1,150 @@
+package sessions
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/qcasey/MDroid-Core-Public/format"
+	"github.com/qcasey/MDroid-Core-Public/format/response"
+	"github.com/qcasey/MDroid-Core-Public/sessions/gps"
+	"github.com/rs/zerolog/log"
+)
+
+// defaultHistorySize is used when HISTORY_SIZE is not set in the MDROID config
+const defaultHistorySize = 10
+
+// history is a bounded ring buffer of recent values for a single session key
+type history struct {
+	entries []Data
+	start   int
+	count   int
+}
+
+func newHistory(size int) *history {
+	return &history{entries: make([]Data, size)}
+}
+
+// push adds a value to the buffer, overwriting the oldest if full
+func (h *history) push(d Data) {
+	if len(h.entries) == 0 {
+		return
+	}
+	h.entries[(h.start+h.count)%len(h.entries)] = d
+	if h.count < len(h.entries) {
+		h.count++
+	} else {
+		h.start = (h.start + 1) % len(h.entries)
+	}
+}
+
+// since returns values updated after the given time, oldest first.
+// If limit is above 0, only the most recent limit values are returned
+func (h *history) since(t time.Time, limit int) []Data {
+	output := []Data{}
+	for i := 0; i < h.count; i++ {
+		d := h.entries[(h.start+i)%len(h.entries)]
+		if d.date.After(t) {
+			output = append(output, d)
+		}
+	}
+	if limit > 0 && len(output) > limit {
+		output = output[len(output)-limit:]
+	}
+	return output
+}
+
+// setupHistory reads global and per key history sizes from the MDROID config,
+// i.e. HISTORY_SIZE=20 and HISTORY_SIZE_MAIN_VOLTAGE=500
+func setupHistory(configMap map[string]string) {
+	session.Mutex.Lock()
+	defer session.Mutex.Unlock()
+
+	for key, value := range configMap {
+		if !strings.HasPrefix(key, "HISTORY_SIZE") {
+			continue
+		}
+		size, err := strconv.Atoi(value)
+		if err != nil || size < 0 {
+			log.Error().Msgf("Invalid history size %s for %s", value, key)
+			continue
+		}
+		if key == "HISTORY_SIZE" {
+			session.historySize = size
+		} else if name := strings.TrimPrefix(key, "HISTORY_SIZE_"); name != key {
+			session.historySizes[name] = size
+		}
+	}
+}
+
+// addHistory records a new value in its key's ring buffer. Session must be locked
+func addHistory(d Data) {
+	h, ok := session.history[d.Name]
+	if !ok {
+		size, ok := session.historySizes[d.Name]
+		if !ok {
+			size = session.historySize
+		}
+		h = newHistory(size)
+		session.history[d.Name] = h
+	}
+	h.push(d)
+}
+
+// GetHistory returns the recent values of the named session, oldest first
+func GetHistory(name string, since time.Time, limit int) ([]Data, error) {
+	session.Mutex.RLock()
+	defer session.Mutex.RUnlock()
+
+	h, ok := session.history[format.Name(name)]
+	if !ok {
+		return nil, fmt.Errorf("%s does not exist in Session", name)
+	}
+	return h.since(since, limit), nil
+}
+
+// parseSince interprets a since parameter as either a duration ago (e.g. 30s)
+// or a timestamp in the same format as LastUpdate
+func parseSince(since string) (time.Time, error) {
+	if since == "" {
+		return time.Time{}, nil
+	}
+	if d, err := time.ParseDuration(since); err == nil {
+		return time.Now().Add(-d), nil
+	}
+	t, err := time.ParseInLocation("2006-01-02 15:04:05.999", since, gps.GetTimezone())
+	if err != nil {
+		return t, fmt.Errorf("Invalid since %s, expected a duration or a timestamp", since)
+	}
+	return t, nil
+}
+
+// HandleGetHistory returns the recent values of a specific session key
+func HandleGetHistory(w http.ResponseWriter, r *http.Request) {
+	params := mux.Vars(r)
+	query := r.URL.Query()
+
+	since, err := parseSince(query.Get("since"))
+	if err != nil {
+		response.WriteNew(&w, r, response.JSONResponse{Output: err.Error(), OK: false})
+		return
+	}
+
+	limit := 0
+	if limitString := query.Get("limit"); limitString != "" {
+		if limit, err = strconv.Atoi(limitString); err != nil {
+			response.WriteNew(&w, r, response.JSONResponse{Output: fmt.Sprintf("Invalid limit %s", limitString), OK: false})
+			return
+		}
+	}
+
+	values, err := GetHistory(params["name"], since, limit)
+	if err != nil {
+		response.WriteNew(&w, r, response.JSONResponse{Output: err.Error(), OK: false})
+		return
+	}
+	response.WriteNew(&w, r, response.JSONResponse{Output: values, OK: true})
+}
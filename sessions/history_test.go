@@ -0,0 +1,38 @@
+package sessions
+
+import (
+	"testing"
+	"time"
+)
+
+func TestHistory(t *testing.T) {
+	start := time.Now()
+	h := newHistory(3)
+	for i := 1; i <= 5; i++ {
+		h.push(Data{Value: int64(i), Type: TypeInt, date: start.Add(time.Duration(i) * time.Second)})
+	}
+
+	tables := []struct {
+		since  time.Time
+		limit  int
+		output []int64
+	}{
+		{time.Time{}, 0, []int64{3, 4, 5}},
+		{time.Time{}, 2, []int64{4, 5}},
+		{start.Add(4 * time.Second), 0, []int64{5}},
+		{start.Add(10 * time.Second), 0, []int64{}},
+	}
+
+	for _, table := range tables {
+		got := h.since(table.since, table.limit)
+		if len(got) != len(table.output) {
+			t.Errorf("since(%s, %d) returned %d values; want %d", table.since, table.limit, len(got), len(table.output))
+			continue
+		}
+		for i, d := range got {
+			if v, _ := d.Int(); v != table.output[i] {
+				t.Errorf("since(%s, %d)[%d] = %d; want %d", table.since, table.limit, i, v, table.output[i])
+			}
+		}
+	}
+}
@@ -1,50 +1,441 @@
 package sessions
 
 import (
+	"fmt"
+	"sort"
+	"strings"
 	"sync"
+	"time"
 
+	"github.com/qcasey/MDroid-Core-Public/format"
 	"github.com/rs/zerolog/log"
 )
 
+// HookFunc is run with a new session value. Returned errors are logged and counted,
+// and from Sync hooks, stop the value from being set
+type HookFunc func(triggerPackage *Data) error
+
+// HookOptions control when a hook is run. The zero value runs the hook in the background on every set.
+// If neither Leading nor Trailing is set, Debounce runs on the trailing edge, and Throttle on both
+type HookOptions struct {
+	Priority int           `json:"priority,omitempty"` // Higher priority hooks are run (or started) first
+	Sync     bool          `json:"sync,omitempty"`     // Run before the value is set, published to streams, MQTT or the DB. Ignores Debounce and Throttle
+	OnChange bool          `json:"onChange,omitempty"` // Only run when the value (or its staleness) changed
+	OnExpire bool          `json:"onExpire,omitempty"` // Also run when the value expires, with it marked Stale
+	Debounce time.Duration `json:"debounce,omitempty"` // Wait until the value stops changing for this long
+	Throttle time.Duration `json:"throttle,omitempty"` // Run at most once per interval
+	Leading  bool          `json:"leading,omitempty"`  // Run with the first value of a debounce or throttle window
+	Trailing bool          `json:"trailing,omitempty"` // Run with the last value of a debounce or throttle window
+	// Don't run for values from these sources, i.e. the hook's own source passed to SetValueFrom
+	IgnoreSources []string `json:"ignoreSources,omitempty"`
+}
+
 type hook struct {
+	id            int
+	name          string
 	componentName string
-	function      func(triggerPackage *Data)
+	filter        format.Filter
+	options       HookOptions
+	function      HookFunc
+	states        map[string]*hookState // Per session name, so one hook can watch a family of keys
+	runs          int
+	failures      int
+	lastRun       time.Time
+	lastError     string
+	totalDuration time.Duration
+	mutex         sync.Mutex
+}
+
+// HookInfo describes a registered hook, and how it's been running
+type HookInfo struct {
+	ID              int         `json:"id"`
+	Watching        string      `json:"watching"`
+	Function        string      `json:"function"`
+	Options         HookOptions `json:"options"`
+	Runs            int         `json:"runs"`
+	Failures        int         `json:"failures"`
+	LastRun         time.Time   `json:"lastRun"`
+	LastError       string      `json:"lastError,omitempty"` // Most recent error, even if later runs succeeded
+	AverageDuration string      `json:"averageDuration"`
+}
+
+// hookState tracks the last value a hook saw, and any debounce or throttle window in progress
+type hookState struct {
+	last       Data
+	seen       bool
+	timer      hookTimer
+	generation int
+	pending    *Data
 }
 
-var hookList []hook
+type hookTimer interface {
+	Stop() bool
+}
+
+var hookList []*hook
 var hookLock sync.Mutex
+var lastHookID int
+
+// Background runs and debounce or throttle windows are started through these, so tests can step through them
+var (
+	startHook = func(run func()) { go run() }
+	afterFunc = func(d time.Duration, f func()) hookTimer { return time.AfterFunc(d, f) }
+)
 
 func init() {
 }
 
-// RegisterHook adds a new hook, watching for componentName (or all components if name is "")
-func RegisterHook(componentName string, function func(triggerPackage *Data)) {
+// RegisterHook adds a new hook, watching for componentName (or all components if name is "").
+// Returns an ID for UnregisterHook
+func RegisterHook(componentName string, function HookFunc) int {
+	return RegisterHookWithOptions(componentName, HookOptions{}, function)
+}
+
+// RegisterHookWithOptions adds a new hook watching for componentName, run according to options
+func RegisterHookWithOptions(componentName string, options HookOptions, function HookFunc) int {
 	log.Info().Msgf("Adding new hook for %s", componentName)
-	hookLock.Lock()
-	defer hookLock.Unlock()
-	hookList = append(hookList, hook{componentName: componentName, function: function})
+	return addHook(&hook{componentName: componentName, options: options, function: function})
+}
+
+// RegisterHookFilter adds a new hook, watching every component matching the filter,
+// i.e. format.Filter{Globs: []string{"*_VOLTAGE"}} for a family of keys
+func RegisterHookFilter(filter format.Filter, options HookOptions, function HookFunc) (int, error) {
+	filter, err := filter.Normalize()
+	if err != nil {
+		return 0, err
+	}
+	log.Info().Msgf("Adding new hook for %v", filter)
+	return addHook(&hook{filter: filter, options: options, function: function}), nil
 }
 
 // RegisterHookSlice takes a list of componentNames to apply the same hook to
-func RegisterHookSlice(componentNames *[]string, hook func(triggerPackage *Data)) {
+func RegisterHookSlice(componentNames *[]string, hook HookFunc) []int {
+	var ids []int
 	for _, name := range *componentNames {
-		RegisterHook(name, hook)
+		ids = append(ids, RegisterHook(name, hook))
+	}
+	return ids
+}
+
+// UnregisterHook removes a hook by the ID it was registered with, cancelling any pending runs
+func UnregisterHook(id int) error {
+	hookLock.Lock()
+	defer hookLock.Unlock()
+
+	for i, h := range hookList {
+		if h.id != id {
+			continue
+		}
+		hookList = append(hookList[:i], hookList[i+1:]...)
+
+		h.mutex.Lock()
+		for _, s := range h.states {
+			if s.timer != nil {
+				s.timer.Stop()
+			}
+			s.generation++
+			s.pending = nil
+		}
+		h.mutex.Unlock()
+		return nil
 	}
+	return fmt.Errorf("No hook with ID %d", id)
+}
+
+func addHook(h *hook) int {
+	hookLock.Lock()
+	defer hookLock.Unlock()
+	lastHookID++
+	h.id = lastHookID
+	h.name = format.FuncName(h.function)
+	h.states = make(map[string]*hookState)
+
+	// Keep hooks in order of priority, then registration
+	hookList = append(hookList, h)
+	sort.SliceStable(hookList, func(i, j int) bool { return hookList[i].options.Priority > hookList[j].options.Priority })
+	return h.id
 }
 
-// Runs all hooks registered with a specific component name
-func runHooks(triggerPackage Data) {
+// GetHooks lists every registered hook in the order they're run, and how they've been running
+func GetHooks() []HookInfo {
 	hookLock.Lock()
 	defer hookLock.Unlock()
 
-	if len(hookList) == 0 {
-		// No hooks registered
+	var output []HookInfo
+	for _, h := range hookList {
+		h.mutex.Lock()
+		info := HookInfo{
+			ID:        h.id,
+			Watching:  h.watching(),
+			Function:  h.name,
+			Options:   h.options,
+			Runs:      h.runs,
+			Failures:  h.failures,
+			LastRun:   h.lastRun,
+			LastError: h.lastError,
+		}
+		if h.runs > 0 {
+			info.AverageDuration = (h.totalDuration / time.Duration(h.runs)).String()
+		}
+		h.mutex.Unlock()
+		output = append(output, info)
+	}
+	return output
+}
+
+// watching describes which components the hook is watching, i.e. MAIN_VOLTAGE or TABLET_*
+func (h *hook) watching() string {
+	if h.filter.IsEmpty() {
+		if h.componentName == "" {
+			return "*"
+		}
+		return h.componentName
+	}
+
+	var watched []string
+	watched = append(watched, h.filter.Names...)
+	for _, prefix := range h.filter.Prefixes {
+		watched = append(watched, prefix+"*")
+	}
+	watched = append(watched, h.filter.Globs...)
+	return strings.Join(watched, ",")
+}
+
+// matches returns if the hook is watching the named component
+func (h *hook) matches(name string) bool {
+	if !h.filter.IsEmpty() {
+		return h.filter.Matches(name)
+	}
+	return h.componentName == name || h.componentName == ""
+}
+
+// ignores returns if the hook skips values from a source
+func (h *hook) ignores(source string) bool {
+	return source != "" && format.StringInSlice(source, h.options.IgnoreSources)
+}
+
+// edges returns if the hook should run on the leading and trailing edge of a window
+func (h *hook) edges() (leading bool, trailing bool) {
+	if h.options.Leading || h.options.Trailing {
+		return h.options.Leading, h.options.Trailing
+	}
+	return h.options.Throttle > 0 && h.options.Debounce == 0, true
+}
+
+// run calls the hook, recovering from any panic, and counts the outcome
+func (h *hook) run(triggerPackage Data) (err error) {
+	start := time.Now()
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("panic: %v", r)
+		}
+
+		h.mutex.Lock()
+		h.runs++
+		h.lastRun = start
+		h.totalDuration += time.Since(start)
+		if err != nil {
+			h.failures++
+			h.lastError = err.Error()
+		}
+		h.mutex.Unlock()
+
+		if err != nil {
+			log.Error().Msgf("Hook %s failed on %s: %s", h.name, triggerPackage.Name, err.Error())
+		}
+	}()
+	return h.function(&triggerPackage)
+}
+
+// state returns what the hook tracks for a session name. Hook must be locked
+func (h *hook) state(name string) *hookState {
+	s, ok := h.states[name]
+	if !ok {
+		s = &hookState{}
+		h.states[name] = s
+	}
+	return s
+}
+
+// skips returns if the hook is OnChange, and already saw the value. Hook must be locked
+func (h *hook) skips(triggerPackage Data) bool {
+	s := h.state(triggerPackage.Name)
+	return h.options.OnChange && s.seen && s.last.Stale == triggerPackage.Stale && s.last.equals(triggerPackage)
+}
+
+// remember records the last value the hook saw. Hook must be locked
+func (h *hook) remember(triggerPackage Data) {
+	s := h.state(triggerPackage.Name)
+	s.last = triggerPackage
+	s.seen = true
+}
+
+// changed records the new value, returning false if the hook should skip it. Hook must be locked
+func (h *hook) changed(triggerPackage Data) (*hookState, bool) {
+	if h.skips(triggerPackage) {
+		return nil, false
+	}
+	h.remember(triggerPackage)
+	return h.state(triggerPackage.Name), true
+}
+
+// trigger runs the hook in the background with a new value, or holds onto it according to the hook's options
+func (h *hook) trigger(triggerPackage Data) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	s, ok := h.changed(triggerPackage)
+	if !ok {
 		return
 	}
 
+	switch {
+	case h.options.Debounce > 0:
+		h.debounce(s, triggerPackage)
+	case h.options.Throttle > 0:
+		h.throttle(s, triggerPackage)
+	default:
+		startHook(func() { h.run(triggerPackage) })
+	}
+}
+
+// debounce waits for values to stop arriving before running. Hook must be locked
+func (h *hook) debounce(s *hookState, triggerPackage Data) {
+	leading, trailing := h.edges()
+	if s.timer == nil {
+		if leading {
+			startHook(func() { h.run(triggerPackage) })
+		} else if trailing {
+			s.pending = &triggerPackage
+		}
+	} else {
+		s.timer.Stop()
+		if trailing {
+			s.pending = &triggerPackage
+		}
+	}
+	h.startWindow(s, h.options.Debounce, false)
+}
+
+// throttle runs at most once per interval. Hook must be locked
+func (h *hook) throttle(s *hookState, triggerPackage Data) {
+	leading, trailing := h.edges()
+	if s.timer != nil {
+		if trailing {
+			s.pending = &triggerPackage
+		}
+		return
+	}
+
+	if leading {
+		startHook(func() { h.run(triggerPackage) })
+	} else if trailing {
+		s.pending = &triggerPackage
+	}
+	h.startWindow(s, h.options.Throttle, true)
+}
+
+// startWindow runs any pending value once the window closes. Throttled windows
+// are restarted after a trailing run, so it still counts toward the interval. Hook must be locked
+func (h *hook) startWindow(s *hookState, window time.Duration, restart bool) {
+	s.generation++
+	generation := s.generation
+	s.timer = afterFunc(window, func() {
+		h.mutex.Lock()
+		defer h.mutex.Unlock()
+		if s.generation != generation {
+			// Replaced or cancelled since
+			return
+		}
+
+		s.timer = nil
+		if s.pending == nil {
+			return
+		}
+		triggerPackage := *s.pending
+		s.pending = nil
+		startHook(func() { h.run(triggerPackage) })
+
+		if restart {
+			h.startWindow(s, window, restart)
+		}
+	})
+}
+
+// runHooks runs all hooks registered with a specific component name. Sync hooks are run first, in order
+// of priority, and the first error stops the value going any further. The rest are started in the background
+func runHooks(triggerPackage Data) error {
+	return runMatching(matchingHooks(triggerPackage, false), triggerPackage)
+}
+
+// runSyncHooks runs only the sync hooks watching a new value, so it can be rejected before it's set
+func runSyncHooks(triggerPackage Data) error {
+	return runSync(matchingHooks(triggerPackage, false), triggerPackage)
+}
+
+// startHooks starts the hooks watching a value that aren't sync, once it's set
+func startHooks(triggerPackage Data) {
+	startBackground(matchingHooks(triggerPackage, false), triggerPackage)
+}
+
+// runExpiredHooks runs the hooks registered with OnExpire, with a value that has just expired
+func runExpiredHooks(triggerPackage Data) error {
+	return runMatching(matchingHooks(triggerPackage, true), triggerPackage)
+}
+
+// matchingHooks returns the hooks watching the value, only those run on expiry if it expired
+func matchingHooks(triggerPackage Data, expired bool) []*hook {
+	hookLock.Lock()
+	defer hookLock.Unlock()
+
+	var matching []*hook
 	for _, h := range hookList {
-		if h.componentName == triggerPackage.Name || h.componentName == "" {
-			go h.function(&triggerPackage)
+		if h.matches(triggerPackage.Name) && !h.ignores(triggerPackage.Source) && (!expired || h.options.OnExpire) {
+			matching = append(matching, h)
+		}
+	}
+	return matching
+}
+
+// runMatching runs the sync hooks, then starts the rest
+func runMatching(matching []*hook, triggerPackage Data) error {
+	if err := runSync(matching, triggerPackage); err != nil {
+		return err
+	}
+	startBackground(matching, triggerPackage)
+	return nil
+}
+
+// runSync runs each sync hook in order, returning the first error. The value isn't remembered
+// for OnChange until startBackground, so a rejected value is checked again the next time it's set.
+// Hooks can set values themselves, so none are run while holding the hook lock
+func runSync(matching []*hook, triggerPackage Data) error {
+	for _, h := range matching {
+		if !h.options.Sync {
+			continue
+		}
+		h.mutex.Lock()
+		skip := h.skips(triggerPackage)
+		h.mutex.Unlock()
+		if skip {
+			continue
+		}
+		if err := h.run(triggerPackage); err != nil {
+			return fmt.Errorf("Hook %s rejected %s: %s", h.name, triggerPackage.Name, err.Error())
+		}
+	}
+	return nil
+}
+
+// startBackground triggers each hook that isn't sync, and has sync hooks remember the value now it's set
+func startBackground(matching []*hook, triggerPackage Data) {
+	for _, h := range matching {
+		if !h.options.Sync {
+			h.trigger(triggerPackage)
+			continue
 		}
+		h.mutex.Lock()
+		h.remember(triggerPackage)
+		h.mutex.Unlock()
 	}
 }
@@ -0,0 +1,234 @@
+package sessions
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeClock holds background hook runs and debounce or throttle windows until the test steps through them
+type fakeClock struct {
+	now     time.Duration
+	timers  []*fakeTimer
+	started []func()
+	mutex   sync.Mutex
+}
+
+type fakeTimer struct {
+	at      time.Duration
+	f       func()
+	stopped bool
+	clock   *fakeClock
+}
+
+func (t *fakeTimer) Stop() bool {
+	t.clock.mutex.Lock()
+	defer t.clock.mutex.Unlock()
+	active := !t.stopped
+	t.stopped = true
+	return active
+}
+
+func (c *fakeClock) afterFunc(d time.Duration, f func()) hookTimer {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	t := &fakeTimer{at: c.now + d, f: f, clock: c}
+	c.timers = append(c.timers, t)
+	return t
+}
+
+func (c *fakeClock) start(run func()) {
+	c.mutex.Lock()
+	c.started = append(c.started, run)
+	c.mutex.Unlock()
+}
+
+// advance moves the clock forward, firing timers as they come due, then runs every hook started
+func (c *fakeClock) advance(d time.Duration) {
+	c.mutex.Lock()
+	end := c.now + d
+	c.mutex.Unlock()
+
+	for {
+		c.mutex.Lock()
+		var next *fakeTimer
+		for _, t := range c.timers {
+			if !t.stopped && t.at <= end && (next == nil || t.at < next.at) {
+				next = t
+			}
+		}
+		if next == nil {
+			c.now = end
+			c.mutex.Unlock()
+			break
+		}
+		next.stopped = true
+		c.now = next.at
+		c.mutex.Unlock()
+		next.f()
+	}
+
+	for {
+		c.mutex.Lock()
+		if len(c.started) == 0 {
+			c.mutex.Unlock()
+			return
+		}
+		run := c.started[0]
+		c.started = c.started[1:]
+		c.mutex.Unlock()
+		run()
+	}
+}
+
+func TestHookOptions(t *testing.T) {
+	clock := &fakeClock{}
+	defaultStart, defaultAfterFunc := startHook, afterFunc
+	startHook, afterFunc = clock.start, clock.afterFunc
+	defer func() { startHook, afterFunc = defaultStart, defaultAfterFunc }()
+
+	tables := []struct {
+		name    string
+		options HookOptions
+		values  []int64
+		output  []int64
+	}{
+		{"HOOK_EVERY_SET", HookOptions{}, []int64{1, 1, 2}, []int64{1, 1, 2}},
+		{"HOOK_ON_CHANGE", HookOptions{OnChange: true}, []int64{1, 1, 2, 2, 1}, []int64{1, 2, 1}},
+		{"HOOK_DEBOUNCE", HookOptions{Debounce: 30 * time.Millisecond}, []int64{1, 2, 3}, []int64{3}},
+		{"HOOK_DEBOUNCE_LEADING", HookOptions{Debounce: 30 * time.Millisecond, Leading: true}, []int64{1, 2, 3}, []int64{1}},
+		{"HOOK_THROTTLE", HookOptions{Throttle: 30 * time.Millisecond}, []int64{1, 2, 3}, []int64{1, 3}},
+		{"HOOK_THROTTLE_TRAILING", HookOptions{Throttle: 30 * time.Millisecond, Trailing: true}, []int64{1, 2, 3}, []int64{3}},
+		{"HOOK_IGNORE_SOURCE", HookOptions{IgnoreSources: []string{SourceInternal}}, []int64{1, 2}, nil},
+	}
+
+	for _, table := range tables {
+		var got []int64
+		id := RegisterHookWithOptions(table.name, table.options, func(triggerPackage *Data) error {
+			v, _ := triggerPackage.Int()
+			got = append(got, v)
+			return nil
+		})
+
+		for _, v := range table.values {
+			SetValue(table.name, v)
+			clock.advance(time.Millisecond)
+		}
+		clock.advance(100 * time.Millisecond)
+
+		if err := UnregisterHook(id); err != nil {
+			t.Errorf("UnregisterHook(%d) returned error %s", id, err.Error())
+		}
+		SetValue(table.name, int64(100))
+		clock.advance(100 * time.Millisecond)
+
+		if fmt.Sprint(got) != fmt.Sprint(table.output) {
+			t.Errorf("%s ran with %v; want %v", table.name, got, table.output)
+		}
+	}
+}
+
+func TestSyncHooks(t *testing.T) {
+	var order []string
+	validate := RegisterHookWithOptions("HOOK_SYNC", HookOptions{Sync: true}, func(triggerPackage *Data) error {
+		order = append(order, "validate")
+		if v, _ := triggerPackage.Int(); v < 0 {
+			return fmt.Errorf("%d is negative", v)
+		}
+		return nil
+	})
+	first := RegisterHookWithOptions("HOOK_SYNC", HookOptions{Sync: true, Priority: 10}, func(triggerPackage *Data) error {
+		order = append(order, "first")
+		return nil
+	})
+	panics := RegisterHookWithOptions("HOOK_SYNC", HookOptions{Sync: true, Priority: -10}, func(triggerPackage *Data) error {
+		panic("hook panicked")
+	})
+
+	if _, err := Set(Data{Name: "HOOK_SYNC", Value: int64(-1)}); err == nil {
+		t.Errorf("Set with a rejected value should have failed")
+	}
+	if d, err := Get("HOOK_SYNC"); err == nil {
+		t.Errorf("Rejected value was set as %v", d.Value)
+	}
+	if len(order) != 2 || order[0] != "first" || order[1] != "validate" {
+		t.Errorf("Sync hooks ran in order %v; want [first validate]", order)
+	}
+
+	if _, err := Set(Data{Name: "HOOK_SYNC", Value: int64(1)}); err == nil {
+		t.Errorf("Set with a panicking hook should have failed")
+	}
+
+	// The panicking hook only ran once validation passed
+	want := map[int]int{validate: 2, first: 2, panics: 1}
+	for _, info := range GetHooks() {
+		if runs, ok := want[info.ID]; ok && info.Runs != runs {
+			t.Errorf("Hook %d ran %d times; want %d", info.ID, info.Runs, runs)
+		}
+	}
+	for id := range want {
+		UnregisterHook(id)
+	}
+}
+
+func TestSyncOnChangeHooks(t *testing.T) {
+	var runs []int64
+	id := RegisterHookWithOptions("HOOK_SYNC_CHANGE", HookOptions{Sync: true, OnChange: true}, func(triggerPackage *Data) error {
+		v, _ := triggerPackage.Int()
+		runs = append(runs, v)
+		if v > 1000 {
+			return fmt.Errorf("%d is too large", v)
+		}
+		return nil
+	})
+	defer UnregisterHook(id)
+
+	// Rejected values, and those that lost a version conflict, are checked again when set again
+	wrongVersion := uint64(99)
+	tables := []struct {
+		value     int64
+		condition Precondition
+		err       bool
+	}{
+		{9000, Precondition{}, true},
+		{9000, Precondition{}, true},
+		{5, Precondition{Version: &wrongVersion}, true},
+		{5, Precondition{}, false},
+		{5, Precondition{}, false},
+	}
+	for _, table := range tables {
+		if _, err := SetIf(Data{Name: "HOOK_SYNC_CHANGE", Value: table.value}, table.condition); (err != nil) != table.err {
+			t.Errorf("SetIf(%d, %+v) returned %v; want error %v", table.value, table.condition, err, table.err)
+		}
+	}
+
+	if want := []int64{9000, 9000, 5, 5}; fmt.Sprint(runs) != fmt.Sprint(want) {
+		t.Errorf("Hook ran with %v; want %v", runs, want)
+	}
+	if v, err := GetInt("HOOK_SYNC_CHANGE"); err != nil || v != 5 {
+		t.Errorf("GetInt(HOOK_SYNC_CHANGE) = %d, %v; want 5", v, err)
+	}
+}
+
+func TestExpiredHooks(t *testing.T) {
+	var got []string
+	every := RegisterHookWithOptions("HOOK_EXPIRE", HookOptions{Sync: true}, func(triggerPackage *Data) error {
+		got = append(got, fmt.Sprintf("every stale=%v", triggerPackage.Stale))
+		return nil
+	})
+	onExpire := RegisterHookWithOptions("HOOK_EXPIRE", HookOptions{Sync: true, OnExpire: true}, func(triggerPackage *Data) error {
+		got = append(got, fmt.Sprintf("onExpire stale=%v", triggerPackage.Stale))
+		return nil
+	})
+	defer UnregisterHook(every)
+	defer UnregisterHook(onExpire)
+
+	runHooks(Data{Name: "HOOK_EXPIRE", Value: int64(1), Type: TypeInt})
+	runExpiredHooks(Data{Name: "HOOK_EXPIRE", Value: int64(1), Type: TypeInt, Stale: true})
+
+	want := []string{"every stale=false", "onExpire stale=false", "onExpire stale=true"}
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("Hooks ran with %v; want %v", got, want)
+	}
+}
@@ -0,0 +1,161 @@
+package sessions
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/qcasey/MDroid-Core-Public/format"
+	"github.com/qcasey/MDroid-Core-Public/format/response"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	// OnInvalidReject refuses to set values outside of their key's spec
+	OnInvalidReject = "REJECT"
+	// OnInvalidFlag sets values outside of their key's spec, but marks them OutOfSpec
+	OnInvalidFlag = "FLAG"
+)
+
+// KeyInfo describes a known session key, what unit it's in, and what values are valid for it
+type KeyInfo struct {
+	Name        string    `json:"name"` // Key, or key prefix ending in *
+	Description string    `json:"description,omitempty"`
+	Unit        string    `json:"unit,omitempty"`
+	Type        ValueType `json:"type,omitempty"`
+	Min         *float64  `json:"min,omitempty"`
+	Max         *float64  `json:"max,omitempty"`
+	Enum        []string  `json:"enum,omitempty"`
+	OnInvalid   string    `json:"onInvalid,omitempty"` // REJECT (default) or FLAG
+}
+
+var registry = struct {
+	keys  map[string]KeyInfo
+	mutex sync.RWMutex
+}{keys: make(map[string]KeyInfo)}
+
+// setupSchema loads known session keys from SESSION_SCHEMA_FILE, a JSON map of keys (or key prefixes ending in *) to their KeyInfo, i.e.
+// {"MAIN_VOLTAGE": {"description": "Main battery", "unit": "V", "type": "float", "min": 0, "max": 16}}
+func setupSchema(configMap map[string]string) {
+	file, ok := configMap["SESSION_SCHEMA_FILE"]
+	if !ok || file == "" {
+		return
+	}
+
+	if err := ReadSchema(file); err != nil {
+		log.Error().Msgf("Could not read session schema from '%s': %s", file, err.Error())
+	}
+}
+
+// ReadSchema replaces the known session keys with those in the given file
+func ReadSchema(file string) error {
+	contents, err := ioutil.ReadFile(file)
+	if err != nil {
+		return err
+	}
+
+	var keys map[string]KeyInfo
+	decoder := json.NewDecoder(bytes.NewReader(contents))
+	decoder.DisallowUnknownFields()
+	if err = decoder.Decode(&keys); err != nil {
+		return err
+	}
+
+	parsed := make(map[string]KeyInfo, len(keys))
+	for name, info := range keys {
+		info.Name = format.Name(name)
+		info.OnInvalid = strings.ToUpper(info.OnInvalid)
+		switch info.OnInvalid {
+		case "":
+			info.OnInvalid = OnInvalidReject
+		case OnInvalidReject, OnInvalidFlag:
+		default:
+			return fmt.Errorf("%s has unknown onInvalid %s, expected REJECT or FLAG", info.Name, info.OnInvalid)
+		}
+		switch info.Type {
+		case "", TypeBool, TypeInt, TypeFloat, TypeString, TypeObject:
+		default:
+			return fmt.Errorf("%s has unknown type %s", info.Name, info.Type)
+		}
+		for i, allowed := range info.Enum {
+			info.Enum[i] = strings.ToUpper(allowed)
+		}
+		parsed[info.Name] = info
+	}
+
+	registry.mutex.Lock()
+	registry.keys = parsed
+	registry.mutex.Unlock()
+
+	log.Info().Msgf("Loaded %d session keys from '%s'", len(parsed), file)
+	return nil
+}
+
+// GetKeyInfo finds what's known about a session key, preferring an exact match over the longest prefix
+func GetKeyInfo(name string) (KeyInfo, bool) {
+	registry.mutex.RLock()
+	defer registry.mutex.RUnlock()
+
+	if info, ok := registry.keys[name]; ok {
+		return info, true
+	}
+
+	var match KeyInfo
+	matchLength := -1
+	for pattern, info := range registry.keys {
+		prefix := strings.TrimSuffix(pattern, "*")
+		if prefix != pattern && strings.HasPrefix(name, prefix) && len(prefix) > matchLength {
+			match = info
+			matchLength = len(prefix)
+		}
+	}
+	return match, matchLength >= 0
+}
+
+// GetSchema returns every known session key
+func GetSchema() []KeyInfo {
+	registry.mutex.RLock()
+	defer registry.mutex.RUnlock()
+
+	output := make([]KeyInfo, 0, len(registry.keys))
+	for _, info := range registry.keys {
+		output = append(output, info)
+	}
+	sort.Slice(output, func(i, j int) bool { return output[i].Name < output[j].Name })
+	return output
+}
+
+// validate returns why a value is out of spec for its key, if it is
+func (info KeyInfo) validate(d Data) error {
+	if info.Type != "" && d.Type != info.Type {
+		return fmt.Errorf("expected a %s, got a %s", info.Type, d.Type)
+	}
+
+	if info.Min != nil || info.Max != nil {
+		v, err := d.Float()
+		if err != nil {
+			return fmt.Errorf("expected a number")
+		}
+		if info.Min != nil && v < *info.Min {
+			return fmt.Errorf("%s is below the minimum of %s", formatValue(d.Value), formatValue(*info.Min))
+		}
+		if info.Max != nil && v > *info.Max {
+			return fmt.Errorf("%s is above the maximum of %s", formatValue(d.Value), formatValue(*info.Max))
+		}
+	}
+
+	if len(info.Enum) > 0 && !format.StringInSlice(strings.ToUpper(d.ValueString()), info.Enum) {
+		return fmt.Errorf("%s is not one of %s", d.ValueString(), strings.Join(info.Enum, ", "))
+	}
+	return nil
+}
+
+// HandleGetSchema returns every known session key, with its unit and valid values
+func HandleGetSchema(w http.ResponseWriter, r *http.Request) {
+	response.WriteNew(&w, r, response.JSONResponse{Output: GetSchema(), OK: true})
+}
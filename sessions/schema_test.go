@@ -0,0 +1,42 @@
+package sessions
+
+import "testing"
+
+func TestSchemaValidation(t *testing.T) {
+	min, max := 0.0, 16.0
+	registry.mutex.Lock()
+	registry.keys = map[string]KeyInfo{
+		"SCHEMA_VOLTAGE": {Name: "SCHEMA_VOLTAGE", Unit: "V", Type: TypeFloat, Min: &min, Max: &max, OnInvalid: OnInvalidReject},
+		"SCHEMA_*":       {Name: "SCHEMA_*", Enum: []string{"ON", "OFF"}, OnInvalid: OnInvalidFlag},
+	}
+	registry.mutex.Unlock()
+
+	tables := []struct {
+		d         Data
+		err       bool
+		valueType ValueType
+		outOfSpec bool
+	}{
+		{Data{Name: "SCHEMA_VOLTAGE", Value: "12"}, false, TypeFloat, false},
+		{Data{Name: "SCHEMA_VOLTAGE", Value: 9000}, true, "", false},
+		{Data{Name: "SCHEMA_VOLTAGE", Value: "HIGH"}, true, "", false},
+		{Data{Name: "SCHEMA_LIGHT", Value: "on"}, false, TypeString, false},
+		{Data{Name: "SCHEMA_LIGHT", Value: "DIM"}, false, TypeString, true},
+		{Data{Name: "OTHER_VOLTAGE", Value: 9000}, false, TypeInt, false},
+	}
+
+	for _, table := range tables {
+		d, err := prepare(table.d)
+		if (err != nil) != table.err {
+			t.Errorf("prepare(%+v) error = %v; want error %v", table.d, err, table.err)
+			continue
+		}
+		if err == nil && (d.Type != table.valueType || d.OutOfSpec != table.outOfSpec) {
+			t.Errorf("prepare(%+v) = %s, out of spec %v; want %s, out of spec %v", table.d, d.Type, d.OutOfSpec, table.valueType, table.outOfSpec)
+		}
+	}
+
+	registry.mutex.Lock()
+	registry.keys = make(map[string]KeyInfo)
+	registry.mutex.Unlock()
+}
@@ -17,11 +17,17 @@ import (
 
 // Data holds the data and last update info for each session value
 type Data struct {
-	Name       string `json:"name,omitempty"`
-	Value      string `json:"value,omitempty"`
-	LastUpdate string `json:"lastUpdate,omitempty"`
+	Name       string      `json:"name,omitempty"`
+	Value      interface{} `json:"value,omitempty"`
+	Type       ValueType   `json:"type,omitempty"`
+	LastUpdate string      `json:"lastUpdate,omitempty"`
 	date       time.Time
-	Quiet      bool `json:"quiet,omitempty"`
+	Quiet      bool   `json:"quiet,omitempty"`
+	Restored   bool   `json:"restored,omitempty"`  // Value was restored from the session file, not set since startup
+	Stale      bool   `json:"stale,omitempty"`     // Value may no longer reflect reality
+	OutOfSpec  bool   `json:"outOfSpec,omitempty"` // Value is outside of its key's registered spec
+	Source     string `json:"source,omitempty"`    // Where the value came from, i.e. serial, rest or mqtt
+	Version    uint64 `json:"version,omitempty"`   // Increases with every set, starting at 1
 }
 
 // Stats hold simple metrics for the session as a whole
@@ -37,7 +43,14 @@ type Stats struct {
 // Session is a mapping of Datas, which contain session values
 type Session struct {
 	data              map[string]Data
+	history           map[string]*history
+	historySize       int
+	historySizes      map[string]int
+	maxAges           map[string]maxAge
+	dropped           map[string]time.Time // Values dropped once expired, and when, until they're set again
 	stats             Stats
+	keyStats          map[string]*keyStats
+	alarms            []*alarm
 	Mutex             sync.RWMutex
 	file              string
 	startTime         time.Time
@@ -48,7 +61,13 @@ var session Session
 
 func init() {
 	session.data = make(map[string]Data)
+	session.history = make(map[string]*history)
+	session.historySize = defaultHistorySize
+	session.historySizes = make(map[string]int)
+	session.maxAges = make(map[string]maxAge)
+	session.dropped = make(map[string]time.Time)
 	session.stats.dataSample = list.New()
+	session.keyStats = make(map[string]*keyStats)
 	session.startTime = time.Now()
 	session.throughputWarning = -1
 }
@@ -57,8 +76,21 @@ func init() {
 func Setup(configAddr *map[string]string) {
 	configMap := *configAddr
 
+	// Setup history sizes before any values are set
+	setupHistory(configMap)
+
+	// Load known keys before any values are set, so they're all validated
+	setupSchema(configMap)
+
 	InitializeDefaults()
 
+	// Restore the last session, before any modules start setting values
+	setupFile(configMap)
+	setupExpiry(configMap)
+	setupStats(configMap)
+	setupSinks(configMap)
+	setupDerived()
+
 	// Set up Auth tokens
 	/*
 		token, usingTokens := configMap["AUTH_TOKEN"]
@@ -106,7 +138,7 @@ func (s *Stats) calcThroughput() {
 
 	if session.throughputWarning >= 0 && session.stats.throughput < float64(session.throughputWarning) {
 		session.stats.DipsBelowMinimum++
-		SlackAlert("Throughput has fallen below 20 sets/second")
+		SlackAlert(fmt.Sprintf("Throughput has fallen below %d sets/second", session.throughputWarning))
 	}
 }
 
@@ -6,19 +6,35 @@ import (
 	"fmt"
 	"io/ioutil"
 	"net/http"
-	"strconv"
-	"strings"
 	"time"
 
 	"github.com/gorilla/mux"
-	"github.com/qcasey/MDroid-Core-Public/db"
+	"github.com/qcasey/MDroid-Core-Public/events"
 	"github.com/qcasey/MDroid-Core-Public/format"
 	"github.com/qcasey/MDroid-Core-Public/format/response"
-	"github.com/qcasey/MDroid-Core-Public/mqtt"
 	"github.com/qcasey/MDroid-Core-Public/sessions/gps"
 	"github.com/rs/zerolog/log"
 )
 
+// Sources of session values, set by each ingest path
+const (
+	SourceInternal = "internal" // Set with SetValue
+	SourceSerial   = "serial"
+	SourceREST     = "rest"
+	SourceGraphQL  = "graphql"
+	SourceMQTT     = "mqtt"
+	SourceHook     = "hook"
+	SourceDerived  = "derived"
+)
+
+// requestSource returns where a REST request came from
+func requestSource(r *http.Request) string {
+	if source := r.Header.Get(response.SourceHeader); source != "" {
+		return source
+	}
+	return SourceREST
+}
+
 // HandleSet updates or posts a new session value to the common session
 func HandleSet(w http.ResponseWriter, r *http.Request) {
 	body, err := ioutil.ReadAll(r.Body)
@@ -43,19 +59,32 @@ func HandleSet(w http.ResponseWriter, r *http.Request) {
 	}
 
 	params := mux.Vars(r)
-	var newdata Data
+	var request struct {
+		Data
+		IfVersion *uint64     `json:"ifVersion,omitempty"`
+		IfValue   interface{} `json:"ifValue,omitempty"`
+	}
 
-	if err = json.NewDecoder(r.Body).Decode(&newdata); err != nil {
+	// Keep numbers as json.Number, so ints and floats can be told apart
+	decoder := json.NewDecoder(r.Body)
+	decoder.UseNumber()
+	if err = decoder.Decode(&request); err != nil {
 		log.Error().Msgf("Error decoding incoming JSON:\n%s", err.Error())
 		response.Output = err.Error()
 		response.Write(&w, r)
 		return
 	}
 
-	// Call the setter
+	// Call the setter, unless the value changed since the client last saw it
+	newdata := request.Data
 	newdata.Name = params["name"]
-	if err = Set(newdata); err != nil {
+	newdata.Source = requestSource(r)
+	if newdata, err = SetIf(newdata, Precondition{Version: request.IfVersion, Value: request.IfValue}); err != nil {
 		response.Output = err.Error()
+		if conflict, ok := err.(*ConflictError); ok {
+			response.Status = "conflict"
+			response.Output = conflict
+		}
 		response.Write(&w, r)
 		return
 	}
@@ -67,73 +96,175 @@ func HandleSet(w http.ResponseWriter, r *http.Request) {
 	response.Write(&w, r)
 }
 
-// SetValue prepares a Value structure before passing it to the setter
-func SetValue(name string, value string) Data {
-	newPackage := Data{Name: name, Value: value, Quiet: true}
-	Set(newPackage)
+// SetValue prepares a Value structure before passing it to the setter.
+// The value's type is inferred from the value itself
+func SetValue(name string, value interface{}) Data {
+	return SetValueFrom(name, value, SourceInternal)
+}
+
+// SetValueFrom is SetValue, recording where the value came from.
+// Hooks can use their own source (i.e. "hook:seatMemory") and ignore it with HookOptions.IgnoreSources
+func SetValueFrom(name string, value interface{}, source string) Data {
+	newPackage, err := Set(Data{Name: name, Value: value, Quiet: true, Source: source})
+	if err != nil {
+		log.Debug().Msg(err.Error())
+	}
 	return newPackage
 }
 
-// Set does the actual setting of Session Values
-func Set(newPackage Data) error {
+// Set does the actual setting of Session Values, returning the value as stored
+func Set(newPackage Data) (Data, error) {
+	return SetIf(newPackage, Precondition{})
+}
+
+// Precondition must hold for a value to be set. The zero value always holds
+type Precondition struct {
+	Version *uint64     // Current version must match. 0 means the value must not exist yet
+	Value   interface{} // Current value must match, once coerced into the current value's type
+}
+
+// ConflictError is returned when a value isn't set because its precondition didn't hold
+type ConflictError struct {
+	Name    string `json:"name"`
+	Reason  string `json:"reason"`
+	Current *Data  `json:"current,omitempty"`
+}
+
+func (e *ConflictError) Error() string {
+	return fmt.Sprintf("Conflict setting %s: %s", e.Name, e.Reason)
+}
+
+// check returns a ConflictError if the precondition doesn't hold for a value. Session must be locked
+func (p Precondition) check(name string) error {
+	current, exists := session.data[name]
+	conflict := &ConflictError{Name: name}
+	if exists {
+		conflict.Current = &current
+	}
+
+	if p.Version != nil && current.Version != *p.Version {
+		conflict.Reason = fmt.Sprintf("expected version %d, is %d", *p.Version, current.Version)
+		return conflict
+	}
+
+	if p.Value != nil {
+		if !exists {
+			conflict.Reason = "expected a value, but it isn't set"
+			return conflict
+		}
+		value, valueType, err := parseValue(p.Value, current.Type)
+		if err != nil || !current.equals(Data{Value: value, Type: valueType}) {
+			conflict.Reason = fmt.Sprintf("expected %s, is %s", formatValue(p.Value), current.ValueString())
+			return conflict
+		}
+	}
+	return nil
+}
+
+// SetIf sets a value only if its precondition holds at the moment it's set, returning a ConflictError if not
+func SetIf(newPackage Data, condition Precondition) (Data, error) {
+	newPackage, err := prepare(newPackage)
+	if err != nil {
+		return newPackage, err
+	}
+
+	// Sync hooks can reject the value before it's set
+	if err := runSyncHooks(newPackage); err != nil {
+		return newPackage, err
+	}
+
+	// Add / update value in global session after locking access to session
+	session.Mutex.Lock()
+	if err := condition.check(newPackage.Name); err != nil {
+		session.Mutex.Unlock()
+		return newPackage, err
+	}
+	oldPackage, exists := commit(&newPackage)
+	session.Mutex.Unlock()
+
+	return newPackage, finish(newPackage, oldPackage, exists)
+}
+
+// prepare validates and normalizes a new value before it's committed to the session
+func prepare(newPackage Data) (Data, error) {
 	// Ensure name is valid
 	if !format.IsValidName(newPackage.Name) {
-		return fmt.Errorf("%s is not a valid name. Possibly a failed serial transmission?", newPackage.Name)
+		return newPackage, fmt.Errorf("%s is not a valid name. Possibly a failed serial transmission?", newPackage.Name)
 	}
 
-	// Set last updated time to now
-	newPackage.date = time.Now().In(gps.GetTimezone())
-	newPackage.LastUpdate = newPackage.date.Format("2006-01-02 15:04:05.999")
-
 	// Correct name
 	newPackage.Name = format.Name(newPackage.Name)
 
-	// Trim off whitespace
-	newPackage.Value = strings.TrimSpace(newPackage.Value)
+	// Determine the value's type, or coerce it into the one requested (or registered for this key)
+	info, known := GetKeyInfo(newPackage.Name)
+	requestedType := newPackage.Type
+	if requestedType == "" && known {
+		requestedType = info.Type
+	}
+	value, valueType, err := parseValue(newPackage.Value, requestedType)
+	if err != nil && newPackage.Type == "" && known && info.Type != "" {
+		// Doesn't fit the registered type, let validation decide what happens to it
+		value, valueType, err = parseValue(newPackage.Value, "")
+	}
+	if err != nil {
+		return newPackage, fmt.Errorf("Invalid value for %s: %s", newPackage.Name, err.Error())
+	}
+	newPackage.Value = value
+	newPackage.Type = valueType
 
-	// Add / update value in global session after locking access to session
-	session.Mutex.Lock()
+	// Check the value against its registered spec
+	newPackage.OutOfSpec = false
+	if known {
+		if err := info.validate(newPackage); err != nil {
+			if info.OnInvalid != OnInvalidFlag {
+				return newPackage, fmt.Errorf("Rejected %s: %s", newPackage.Name, err.Error())
+			}
+			log.Warn().Msgf("%s is out of spec: %s", newPackage.Name, err.Error())
+			newPackage.OutOfSpec = true
+		}
+	}
+
+	// This is a live value
+	newPackage.Restored = false
+	newPackage.Stale = false
 
+	// Set last updated time to now
+	newPackage.date = time.Now().In(gps.GetTimezone())
+	newPackage.LastUpdate = newPackage.date.Format("2006-01-02 15:04:05.999")
+	return newPackage, nil
+}
+
+// commit adds a prepared value to the session as the next version, returning the value it replaced if one existed.
+// Session must be locked
+func commit(newPackage *Data) (Data, bool) {
 	// Check if this is a new value we should insert into the DB
 	oldPackage, exists := session.data[newPackage.Name]
+	newPackage.Version = oldPackage.Version + 1
 
 	// Add new package to session
-	session.data[newPackage.Name] = newPackage
+	session.data[newPackage.Name] = *newPackage
+	delete(session.dropped, newPackage.Name)
+	addHistory(*newPackage)
 	session.stats.Sets++
-	addStat(newPackage)
-	session.Mutex.Unlock()
-
-	// Finish post processing
-	go runHooks(newPackage)
-
-	// Insert into database if this is a new/updated value
-	if !exists || (exists && oldPackage.Value != newPackage.Value) {
-		formattedName := strings.Replace(newPackage.Name, " ", "_", -1)
-
-		if mqtt.IsConnected() {
-			topic := fmt.Sprintf("session/%s", formattedName)
-			go mqtt.Publish(topic, newPackage.Value)
-		}
+	addStat(*newPackage)
+	addKeyStat(*newPackage)
+	return oldPackage, exists
+}
 
-		if db.DB != nil {
-			// Convert to a float if that suits the value, otherwise change field to value_string
-			valueString := fmt.Sprintf("value=%s", newPackage.Value)
-			if _, err := strconv.ParseFloat(newPackage.Value, 32); err != nil {
-				valueString = fmt.Sprintf("value=\"%s\"", newPackage.Value)
-			}
+// finish starts hooks on a committed value, and sends it out to streams, MQTT and the DB
+func finish(newPackage Data, oldPackage Data, exists bool) error {
+	startHooks(newPackage)
+	runDerived(newPackage)
+	publish(newPackage)
+	events.Publish("session", newPackage.Name, newPackage)
 
-			// In Sessions, all values come in and out as strings regardless,
-			// but this conversion alows Influx queries on the floats to be executed
-			err := db.DB.Write(fmt.Sprintf("%s %s", formattedName, valueString))
-			if err != nil {
-				errorText := fmt.Sprintf("Error writing %s to database:\n%s", valueString, err.Error())
-				// Only spam our log if Influx is online
-				if db.DB.Started {
-					log.Error().Msg(errorText)
-				}
-				return fmt.Errorf(errorText)
-			}
-		}
+	// Send new/updated values on to MQTT and the database, unless they're filtered for either
+	changed := !exists || !oldPackage.equals(newPackage)
+	if shouldWrite(sinkMQTT, newPackage, changed) {
+		publishMQTT(newPackage)
+	}
+	if shouldWrite(sinkDB, newPackage, changed) {
+		return insertDB(newPackage)
 	}
 
 	return nil
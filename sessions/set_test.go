@@ -0,0 +1,33 @@
+package sessions
+
+import "testing"
+
+func TestSetIf(t *testing.T) {
+	zero, one, two := uint64(0), uint64(1), uint64(2)
+	tables := []struct {
+		value     interface{}
+		condition Precondition
+		conflict  bool
+		version   uint64
+	}{
+		{"A", Precondition{Version: &one}, true, 0},
+		{"A", Precondition{Version: &zero}, false, 1},
+		{"B", Precondition{Version: &zero}, true, 1},
+		{"B", Precondition{Version: &one, Value: "A"}, false, 2},
+		{"C", Precondition{Value: "A"}, true, 2},
+		{"C", Precondition{Version: &two, Value: "B"}, false, 3},
+		{"D", Precondition{}, false, 4},
+	}
+
+	for _, table := range tables {
+		d, err := SetIf(Data{Name: "CAS_KEY", Value: table.value}, table.condition)
+		if _, conflict := err.(*ConflictError); conflict != table.conflict {
+			t.Errorf("SetIf(%v, %+v) returned %v; want conflict %v", table.value, table.condition, err, table.conflict)
+			continue
+		}
+		current, _ := Get("CAS_KEY")
+		if current.Version != table.version || (!table.conflict && d.Version != table.version) {
+			t.Errorf("SetIf(%v, %+v) left version %d; want %d", table.value, table.condition, current.Version, table.version)
+		}
+	}
+}
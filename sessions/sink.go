@@ -0,0 +1,295 @@
+package sessions
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/qcasey/MDroid-Core-Public/db"
+	"github.com/qcasey/MDroid-Core-Public/mqtt"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	sinkDB   = "DB"
+	sinkMQTT = "MQTT"
+)
+
+// sinkRule limits how often a key is written to the DB or MQTT. The in-memory session still gets every update
+type sinkRule struct {
+	deadband    float64       // Minimum change from the last written value
+	percent     bool          // Deadband is a percent of the last written value
+	minInterval time.Duration // Minimum time between writes
+	heartbeat   time.Duration // Write at least this often, even without a change
+}
+
+// sinkWrite is the last value written to a sink, and when
+type sinkWrite struct {
+	d    Data
+	date time.Time
+}
+
+// sinkPending is a value held back by a minimum interval, written once the interval is up
+type sinkPending struct {
+	d     Data
+	timer hookTimer
+}
+
+var sinks = struct {
+	rules   map[string]map[string]sinkRule     // By sink, then key or key prefix
+	last    map[string]map[string]sinkWrite    // By sink, then key
+	pending map[string]map[string]*sinkPending // By sink, then key
+	mutex   sync.Mutex
+}{
+	rules:   map[string]map[string]sinkRule{sinkDB: {}, sinkMQTT: {}},
+	last:    map[string]map[string]sinkWrite{sinkDB: {}, sinkMQTT: {}},
+	pending: map[string]map[string]*sinkPending{sinkDB: {}, sinkMQTT: {}},
+}
+
+// setupSinks reads DB and MQTT write filters from the MDROID config, per key or key prefix, as
+// DEADBAND[%],MIN_INTERVAL,HEARTBEAT with intervals in seconds. Any part can be left empty, i.e.
+// DB_FILTER_AUX_CURRENT=0.05,,60 or MQTT_FILTER_MAIN_*=1%,2
+func setupSinks(configMap map[string]string) {
+	sinks.mutex.Lock()
+	defer sinks.mutex.Unlock()
+
+	heartbeats := false
+	for key, value := range configMap {
+		for _, sink := range []string{sinkDB, sinkMQTT} {
+			pattern := strings.TrimPrefix(key, sink+"_FILTER_")
+			if pattern == key || pattern == "" {
+				continue
+			}
+
+			rule, err := parseSinkRule(value)
+			if err != nil {
+				log.Error().Msgf("Invalid filter %s for %s: %s", value, key, err.Error())
+				continue
+			}
+			sinks.rules[sink][pattern] = rule
+			heartbeats = heartbeats || rule.heartbeat > 0
+		}
+	}
+
+	if !heartbeats {
+		return
+	}
+
+	log.Info().Msg("Sending session heartbeats to the DB and MQTT")
+	go func() {
+		for {
+			time.Sleep(time.Second)
+			sendHeartbeats()
+		}
+	}()
+}
+
+func parseSinkRule(value string) (sinkRule, error) {
+	var rule sinkRule
+	parts := strings.Split(value, ",")
+	if len(parts) > 3 {
+		return rule, fmt.Errorf("Expected DEADBAND,MIN_INTERVAL,HEARTBEAT")
+	}
+
+	var numbers [3]float64
+	for i, part := range parts {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		if i == 0 && strings.HasSuffix(part, "%") {
+			rule.percent = true
+			part = strings.TrimSuffix(part, "%")
+		}
+
+		v, err := strconv.ParseFloat(part, 64)
+		if err != nil || v < 0 {
+			return rule, fmt.Errorf("%s is not a positive number", part)
+		}
+		numbers[i] = v
+	}
+
+	rule.deadband = numbers[0]
+	rule.minInterval = time.Duration(numbers[1] * float64(time.Second))
+	rule.heartbeat = time.Duration(numbers[2] * float64(time.Second))
+	return rule, nil
+}
+
+// getSinkRule finds the rule for a key, preferring an exact match over the longest prefix. Sinks must be locked
+func getSinkRule(sink string, name string) (sinkRule, bool) {
+	rules := sinks.rules[sink]
+	if rule, ok := rules[name]; ok {
+		return rule, true
+	}
+
+	var match sinkRule
+	matchLength := -1
+	for pattern, rule := range rules {
+		prefix := strings.TrimSuffix(pattern, "*")
+		if prefix != pattern && strings.HasPrefix(name, prefix) && len(prefix) > matchLength {
+			match = rule
+			matchLength = len(prefix)
+		}
+	}
+	return match, matchLength >= 0
+}
+
+// exceeds returns if the new value is far enough from the last written value
+func (rule sinkRule) exceeds(last Data, d Data) bool {
+	if rule.deadband == 0 || last.Type == TypeString || d.Type == TypeString {
+		return !last.equals(d)
+	}
+
+	lastFloat, lastErr := last.Float()
+	newFloat, newErr := d.Float()
+	if lastErr != nil || newErr != nil {
+		return !last.equals(d)
+	}
+
+	threshold := rule.deadband
+	if rule.percent {
+		threshold = math.Abs(lastFloat) * rule.deadband / 100
+	}
+	return math.Abs(newFloat-lastFloat) > threshold
+}
+
+// shouldWrite returns if a new value should be sent to a sink, recording it as written if so.
+// Without a rule, values are written whenever they change. Values held back only by the
+// minimum interval are written once it's up, unless they're replaced or written before then
+func shouldWrite(sink string, d Data, changed bool) bool {
+	sinks.mutex.Lock()
+	defer sinks.mutex.Unlock()
+
+	rule, ok := getSinkRule(sink, d.Name)
+	if !ok {
+		return changed
+	}
+
+	now := time.Now()
+	last, written := sinks.last[sink][d.Name]
+	if written {
+		elapsed := now.Sub(last.date)
+		heartbeat := rule.heartbeat > 0 && elapsed >= rule.heartbeat
+		if !heartbeat && !rule.exceeds(last.d, d) {
+			// Back within the deadband of what was last written, so nothing is left to catch up on
+			cancelPending(sink, d.Name)
+			return false
+		}
+		if !heartbeat && elapsed < rule.minInterval {
+			deferWrite(sink, d, rule.minInterval-elapsed)
+			return false
+		}
+	}
+
+	cancelPending(sink, d.Name)
+	sinks.last[sink][d.Name] = sinkWrite{d: d, date: now}
+	return true
+}
+
+// deferWrite holds a value until the minimum interval is up, replacing any value already waiting. Sinks must be locked
+func deferWrite(sink string, d Data, wait time.Duration) {
+	if p, ok := sinks.pending[sink][d.Name]; ok {
+		p.d = d
+		return
+	}
+
+	p := &sinkPending{d: d}
+	p.timer = afterFunc(wait, func() { flushPending(sink, d.Name, p) })
+	sinks.pending[sink][d.Name] = p
+}
+
+// cancelPending drops a value waiting on the minimum interval. Sinks must be locked
+func cancelPending(sink string, name string) {
+	if p, ok := sinks.pending[sink][name]; ok {
+		p.timer.Stop()
+		delete(sinks.pending[sink], name)
+	}
+}
+
+// flushPending writes a held back value once its minimum interval is up
+func flushPending(sink string, name string, p *sinkPending) {
+	sinks.mutex.Lock()
+	if sinks.pending[sink][name] != p {
+		// Written or cancelled since
+		sinks.mutex.Unlock()
+		return
+	}
+	delete(sinks.pending[sink], name)
+	sinks.last[sink][name] = sinkWrite{d: p.d, date: time.Now()}
+	d := p.d
+	sinks.mutex.Unlock()
+
+	switch sink {
+	case sinkMQTT:
+		publishMQTT(d)
+	case sinkDB:
+		insertDB(d)
+	}
+}
+
+// sendHeartbeats rewrites current session values that haven't been written within their heartbeat
+func sendHeartbeats() {
+	now := time.Now()
+	due := map[string][]Data{}
+
+	session.Mutex.RLock()
+	sinks.mutex.Lock()
+	for _, sink := range []string{sinkDB, sinkMQTT} {
+		for name, d := range session.data {
+			rule, ok := getSinkRule(sink, name)
+			if !ok || rule.heartbeat == 0 || d.Stale {
+				continue
+			}
+			if last, written := sinks.last[sink][name]; written && now.Sub(last.date) < rule.heartbeat {
+				continue
+			}
+			cancelPending(sink, name)
+			sinks.last[sink][name] = sinkWrite{d: d, date: now}
+			due[sink] = append(due[sink], d)
+		}
+	}
+	sinks.mutex.Unlock()
+	session.Mutex.RUnlock()
+
+	for _, d := range due[sinkMQTT] {
+		publishMQTT(d)
+	}
+	for _, d := range due[sinkDB] {
+		insertDB(d)
+	}
+}
+
+// publishMQTT sends a session value to its MQTT topic, if connected
+func publishMQTT(d Data) {
+	if mqtt.IsConnected() {
+		topic := fmt.Sprintf("session/%s", strings.Replace(d.Name, " ", "_", -1))
+		go mqtt.Publish(topic, d.publishValue())
+	}
+}
+
+// insertDB writes a session value to the DB, if one is configured
+func insertDB(d Data) error {
+	if db.DB == nil {
+		return nil
+	}
+
+	// Tag it with its source, so values can be grouped by where they came from
+	tags := map[string]interface{}{}
+	if d.Source != "" {
+		tags["source"] = d.Source
+	}
+
+	// Write the value with its type, so Influx queries on numbers can be executed
+	err := db.DB.Insert(strings.Replace(d.Name, " ", "_", -1), tags, map[string]interface{}{"value": d.dbValue()})
+	if err != nil {
+		// Only spam our log if Influx is online
+		if db.DB.Started {
+			log.Error().Msg(err.Error())
+		}
+		return err
+	}
+	return nil
+}
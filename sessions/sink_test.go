@@ -0,0 +1,92 @@
+package sessions
+
+import (
+	"testing"
+	"time"
+)
+
+func TestShouldWrite(t *testing.T) {
+	setupSinks(map[string]string{
+		"DB_FILTER_SINK_ABSOLUTE": "0.05",
+		"DB_FILTER_SINK_PERCENT*": "10%",
+		"MQTT_FILTER_SINK_*":      ",1",
+	})
+
+	tables := []struct {
+		sink   string
+		name   string
+		value  float64
+		output bool
+	}{
+		{sinkDB, "SINK_ABSOLUTE", 12, true},
+		{sinkDB, "SINK_ABSOLUTE", 12.04, false},
+		{sinkDB, "SINK_ABSOLUTE", 11.96, false},
+		{sinkDB, "SINK_ABSOLUTE", 12.06, true},
+		{sinkDB, "SINK_PERCENT_1", 100, true},
+		{sinkDB, "SINK_PERCENT_1", 109, false},
+		{sinkDB, "SINK_PERCENT_1", 111, true},
+		{sinkMQTT, "SINK_ABSOLUTE", 12, true},
+		{sinkMQTT, "SINK_ABSOLUTE", 20, false},
+		{sinkDB, "SINK_UNFILTERED", 1, true},
+	}
+
+	for _, table := range tables {
+		d := Data{Name: table.name, Value: table.value, Type: TypeFloat}
+		if got := shouldWrite(table.sink, d, true); got != table.output {
+			t.Errorf("shouldWrite(%s, %s = %f) = %v; want %v", table.sink, table.name, table.value, got, table.output)
+		}
+	}
+
+	// Heartbeats write even without a change
+	sinks.mutex.Lock()
+	sinks.rules[sinkDB]["SINK_ABSOLUTE"] = sinkRule{deadband: 0.05, heartbeat: time.Minute}
+	last := sinks.last[sinkDB]["SINK_ABSOLUTE"]
+	last.date = last.date.Add(-2 * time.Minute)
+	sinks.last[sinkDB]["SINK_ABSOLUTE"] = last
+	sinks.mutex.Unlock()
+	if !shouldWrite(sinkDB, last.d, false) {
+		t.Errorf("shouldWrite should write an unchanged value past its heartbeat")
+	}
+}
+
+func TestDeferredWrite(t *testing.T) {
+	clock := &fakeClock{}
+	defaultAfterFunc := afterFunc
+	afterFunc = clock.afterFunc
+	defer func() { afterFunc = defaultAfterFunc }()
+
+	setupSinks(map[string]string{"DB_FILTER_SINK_DEFERRED": ",5"})
+
+	tables := []struct {
+		values  []float64
+		written float64
+	}{
+		{[]float64{1}, 1},
+		{[]float64{2, 3}, 3}, // Last value held back by the interval is written once it's up
+		{[]float64{4, 3}, 3}, // Returning to the written value leaves nothing to write
+	}
+
+	for i, table := range tables {
+		for _, value := range table.values {
+			d := Data{Name: "SINK_DEFERRED", Value: value, Type: TypeFloat}
+			if got := shouldWrite(sinkDB, d, true); got != (i == 0) {
+				t.Errorf("shouldWrite(SINK_DEFERRED = %f) = %v; want %v", value, got, i == 0)
+			}
+		}
+		clock.advance(5 * time.Second)
+
+		sinks.mutex.Lock()
+		last := sinks.last[sinkDB]["SINK_DEFERRED"]
+		_, pending := sinks.pending[sinkDB]["SINK_DEFERRED"]
+		sinks.mutex.Unlock()
+		if last.d.Value != table.written || pending {
+			t.Errorf("Wrote %v with pending %v after %v; want %f", last.d.Value, pending, table.values, table.written)
+		}
+
+		// Start the next window from now, rather than the fake clock
+		sinks.mutex.Lock()
+		last.date = time.Now()
+		sinks.last[sinkDB]["SINK_DEFERRED"] = last
+		sinks.mutex.Unlock()
+	}
+}
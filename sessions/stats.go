@@ -0,0 +1,277 @@
+package sessions
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/qcasey/MDroid-Core-Public/format/response"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	// statsSampleSize is how many recent sets of a key its update rate is measured over
+	statsSampleSize = 100
+	// rateAlarmGrace is how long an alarm's condition must hold before rates are checked, so there's something to measure
+	rateAlarmGrace = 10 * time.Second
+)
+
+// keyStats track how often a single session key is updated
+type keyStats struct {
+	sets        uint32
+	times       []time.Time // Most recent set times, oldest first
+	minInterval time.Duration
+	maxInterval time.Duration
+	alarms      int
+}
+
+// KeyStats are the update statistics for a single session key
+type KeyStats struct {
+	Name            string  `json:"name"`
+	Sets            uint32  `json:"sets"`
+	Rate            float64 `json:"rate"` // Sets per second, over the most recent sets until now
+	RateString      string  `json:"rateString"`
+	LastUpdate      string  `json:"lastUpdate,omitempty"`
+	LastSource      string  `json:"lastSource,omitempty"`
+	SinceLastUpdate string  `json:"sinceLastUpdate,omitempty"`
+	MinInterval     string  `json:"minInterval,omitempty"`
+	MaxInterval     string  `json:"maxInterval,omitempty"`
+	Alarms          int     `json:"alarms"`
+	Alarming        bool    `json:"alarming"`
+}
+
+// alarm alerts when a key (or every key with a prefix) stops updating, or updates too slowly, while a condition holds
+type alarm struct {
+	pattern        string
+	stall          time.Duration
+	minRate        float64
+	whenName       string
+	whenValue      string
+	conditionSince time.Time
+	active         map[string]bool
+}
+
+// setupStats reads throughput alarms from the MDROID config. Rules are per key or key prefix, with an optional condition:
+// STALL_ALARM_MAIN_VOLTAGE_RAW=30,ACC_POWER=TRUE alerts if MAIN_VOLTAGE_RAW isn't set for 30 seconds while ACC_POWER is TRUE, and
+// RATE_ALARM_GPS_*=0.5 alerts if any GPS_ key is set less than once every 2 seconds
+func setupStats(configMap map[string]string) {
+	session.Mutex.Lock()
+	defer session.Mutex.Unlock()
+
+	for key, value := range configMap {
+		var a *alarm
+		switch {
+		case strings.HasPrefix(key, "STALL_ALARM_"):
+			a = &alarm{pattern: strings.TrimPrefix(key, "STALL_ALARM_")}
+		case strings.HasPrefix(key, "RATE_ALARM_"):
+			a = &alarm{pattern: strings.TrimPrefix(key, "RATE_ALARM_")}
+		default:
+			continue
+		}
+
+		parts := strings.Split(value, ",")
+		threshold, err := strconv.ParseFloat(strings.TrimSpace(parts[0]), 64)
+		if err != nil || threshold <= 0 || a.pattern == "" {
+			log.Error().Msgf("Invalid alarm %s for %s", value, key)
+			continue
+		}
+		if strings.HasPrefix(key, "STALL_ALARM_") {
+			a.stall = time.Duration(threshold * float64(time.Second))
+		} else {
+			a.minRate = threshold
+		}
+
+		if len(parts) > 1 {
+			condition := strings.SplitN(strings.TrimSpace(parts[1]), "=", 2)
+			if len(condition) != 2 {
+				log.Error().Msgf("Invalid alarm condition %s for %s, expected NAME=VALUE", parts[1], key)
+				continue
+			}
+			a.whenName = condition[0]
+			a.whenValue = condition[1]
+		}
+
+		a.conditionSince = time.Now()
+		a.active = make(map[string]bool)
+		session.alarms = append(session.alarms, a)
+	}
+
+	if len(session.alarms) == 0 {
+		return
+	}
+
+	log.Info().Msgf("Checking %d session throughput alarms", len(session.alarms))
+	go func() {
+		for {
+			time.Sleep(time.Second)
+			checkAlarms()
+		}
+	}()
+}
+
+// addKeyStat records a set of a single key. Session must be locked
+func addKeyStat(d Data) {
+	s, ok := session.keyStats[d.Name]
+	if !ok {
+		s = &keyStats{}
+		session.keyStats[d.Name] = s
+	}
+
+	if len(s.times) > 0 {
+		interval := d.date.Sub(s.times[len(s.times)-1])
+		if s.minInterval == 0 || interval < s.minInterval {
+			s.minInterval = interval
+		}
+		if interval > s.maxInterval {
+			s.maxInterval = interval
+		}
+	}
+
+	s.sets++
+	s.times = append(s.times, d.date)
+	if len(s.times) > statsSampleSize {
+		s.times = s.times[len(s.times)-statsSampleSize:]
+	}
+}
+
+// rate returns sets per second from the oldest recent set until now, so it falls once a key stops updating
+func (s *keyStats) rate(now time.Time) float64 {
+	if len(s.times) == 0 {
+		return 0
+	}
+	elapsed := now.Sub(s.times[0]).Seconds()
+	if elapsed <= 0 {
+		return 0
+	}
+	return float64(len(s.times)) / elapsed
+}
+
+// lastSet returns when the key was last set, or zero if it never was
+func (s *keyStats) lastSet() time.Time {
+	if len(s.times) == 0 {
+		return time.Time{}
+	}
+	return s.times[len(s.times)-1]
+}
+
+// matches returns if the alarm covers a session key
+func (a *alarm) matches(name string) bool {
+	prefix := strings.TrimSuffix(a.pattern, "*")
+	if prefix != a.pattern {
+		return strings.HasPrefix(name, prefix)
+	}
+	return name == a.pattern
+}
+
+// checkAlarms sends an alert the first time each alarm fails for a key, until it recovers or its condition no longer holds
+func checkAlarms() {
+	var alerts []string
+	now := time.Now()
+
+	session.Mutex.Lock()
+	for _, a := range session.alarms {
+		if a.whenName != "" {
+			d, ok := session.data[a.whenName]
+			if !ok || d.Stale || d.ValueString() != a.whenValue {
+				// Start over once the condition holds again
+				a.conditionSince = now
+				a.active = make(map[string]bool)
+				continue
+			}
+		}
+
+		// Keys that have never been set can still stall
+		names := []string{}
+		if !strings.HasSuffix(a.pattern, "*") {
+			names = append(names, a.pattern)
+		} else {
+			for name := range session.keyStats {
+				if a.matches(name) {
+					names = append(names, name)
+				}
+			}
+		}
+
+		for _, name := range names {
+			s, ok := session.keyStats[name]
+			if !ok {
+				s = &keyStats{}
+				session.keyStats[name] = s
+			}
+
+			var reason string
+			since := now.Sub(s.lastSet())
+			if s.lastSet().Before(a.conditionSince) {
+				since = now.Sub(a.conditionSince)
+			}
+
+			if a.stall > 0 && since >= a.stall {
+				reason = fmt.Sprintf("%s hasn't updated in %s", name, since.Round(time.Second))
+			} else if a.minRate > 0 && now.Sub(a.conditionSince) >= rateAlarmGrace && s.rate(now) < a.minRate {
+				reason = fmt.Sprintf("%s is updating %.2f times per second, below %.2f", name, s.rate(now), a.minRate)
+			}
+
+			if reason == "" {
+				delete(a.active, name)
+				continue
+			}
+			if a.active[name] {
+				continue
+			}
+			a.active[name] = true
+			s.alarms++
+			if a.whenName != "" {
+				reason = fmt.Sprintf("%s while %s is %s", reason, a.whenName, a.whenValue)
+			}
+			alerts = append(alerts, reason)
+		}
+	}
+	session.Mutex.Unlock()
+
+	for _, alert := range alerts {
+		log.Warn().Msg(alert)
+		go SlackAlert(alert)
+	}
+}
+
+// GetKeyStats returns the update statistics for a single session key
+func GetKeyStats(name string) (KeyStats, error) {
+	session.Mutex.RLock()
+	defer session.Mutex.RUnlock()
+
+	s, ok := session.keyStats[name]
+	if !ok {
+		return KeyStats{}, fmt.Errorf("%s has no statistics", name)
+	}
+
+	now := time.Now()
+	output := KeyStats{Name: name, Sets: s.sets, Rate: s.rate(now), Alarms: s.alarms}
+	output.RateString = fmt.Sprintf("%f sets per second", output.Rate)
+	if s.sets > 0 {
+		output.LastUpdate = session.data[name].LastUpdate
+		output.LastSource = session.data[name].Source
+		output.SinceLastUpdate = now.Sub(s.lastSet()).String()
+	}
+	if s.sets > 1 {
+		output.MinInterval = s.minInterval.String()
+		output.MaxInterval = s.maxInterval.String()
+	}
+	for _, a := range session.alarms {
+		output.Alarming = output.Alarming || a.active[name]
+	}
+	return output, nil
+}
+
+// HandleGetKeyStats returns the update statistics for a single session key
+func HandleGetKeyStats(w http.ResponseWriter, r *http.Request) {
+	params := mux.Vars(r)
+	stats, err := GetKeyStats(params["name"])
+	if err != nil {
+		response.WriteNew(&w, r, response.JSONResponse{Output: err.Error(), OK: false})
+		return
+	}
+	response.WriteNew(&w, r, response.JSONResponse{Output: stats, OK: true})
+}
@@ -0,0 +1,61 @@
+package sessions
+
+import (
+	"testing"
+	"time"
+)
+
+func TestKeyStats(t *testing.T) {
+	start := time.Now().Add(-time.Minute)
+	session.Mutex.Lock()
+	for _, offset := range []time.Duration{0, time.Second, 4 * time.Second, 6 * time.Second} {
+		addKeyStat(Data{Name: "STATS_KEY", date: start.Add(offset)})
+	}
+	session.Mutex.Unlock()
+
+	stats, err := GetKeyStats("STATS_KEY")
+	if err != nil {
+		t.Fatalf("GetKeyStats returned error %s", err.Error())
+	}
+	if stats.Sets != 4 || stats.MinInterval != "1s" || stats.MaxInterval != "3s" {
+		t.Errorf("GetKeyStats = %+v; want 4 sets between 1s and 3s apart", stats)
+	}
+
+	tables := []struct {
+		alarm    alarm
+		alarming bool
+	}{
+		{alarm{pattern: "STATS_KEY", stall: 30 * time.Second}, true},
+		{alarm{pattern: "STATS_*", stall: 30 * time.Second}, true},
+		{alarm{pattern: "STATS_KEY", stall: 2 * time.Minute}, false},
+		{alarm{pattern: "STATS_KEY", minRate: 1}, true},
+		{alarm{pattern: "STATS_KEY", stall: 30 * time.Second, whenName: "STATS_CONDITION", whenValue: "TRUE"}, false},
+		{alarm{pattern: "OTHER_KEY", stall: 30 * time.Second}, false},
+	}
+
+	for _, table := range tables {
+		a := table.alarm
+		a.conditionSince = start
+		a.active = make(map[string]bool)
+
+		session.Mutex.Lock()
+		session.alarms = []*alarm{&a}
+		session.keyStats["STATS_KEY"].alarms = 0
+		session.Mutex.Unlock()
+
+		checkAlarms()
+		checkAlarms()
+
+		stats, _ := GetKeyStats("STATS_KEY")
+		if stats.Alarming != table.alarming {
+			t.Errorf("Alarm %+v alarming = %v; want %v", table.alarm, stats.Alarming, table.alarming)
+		}
+		if table.alarming && stats.Alarms != 1 {
+			t.Errorf("Alarm %+v alerted %d times; want 1", table.alarm, stats.Alarms)
+		}
+	}
+
+	session.Mutex.Lock()
+	session.alarms = nil
+	session.Mutex.Unlock()
+}
@@ -0,0 +1,221 @@
+package sessions
+
+import (
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/qcasey/MDroid-Core-Public/format"
+	"github.com/qcasey/MDroid-Core-Public/format/response"
+	"github.com/rs/zerolog/log"
+)
+
+// streamBufferSize is how many changes a stream client can fall behind before changes are dropped
+const streamBufferSize = 256
+
+// Subscriber receives session changes matching its filter
+type Subscriber struct {
+	C       <-chan Data
+	send    chan Data
+	filter  format.Filter
+	dropped int
+	mutex   sync.Mutex
+}
+
+// streamMessage is what's written to stream clients, either a snapshot or a single change
+type streamMessage struct {
+	Event string      `json:"event"`
+	Data  interface{} `json:"data"`
+}
+
+// streamRequest is sent by stream clients to change their subscription
+type streamRequest struct {
+	format.Filter
+	Snapshot bool `json:"snapshot,omitempty"`
+}
+
+var (
+	subscribers     = make(map[*Subscriber]bool)
+	subscribersLock sync.Mutex
+	upgrader        = websocket.Upgrader{
+		// Dashboards and watch clients aren't served from here
+		CheckOrigin: func(r *http.Request) bool { return true },
+	}
+)
+
+// Subscribe starts sending session changes matching the filter to the returned Subscriber.
+// Changes are dropped rather than blocking Set if the subscriber falls behind bufferSize changes
+func Subscribe(filter format.Filter, bufferSize int) *Subscriber {
+	send := make(chan Data, bufferSize)
+	s := &Subscriber{C: send, send: send, filter: filter}
+
+	subscribersLock.Lock()
+	subscribers[s] = true
+	subscribersLock.Unlock()
+	return s
+}
+
+// Close stops sending changes to the Subscriber
+func (s *Subscriber) Close() {
+	subscribersLock.Lock()
+	defer subscribersLock.Unlock()
+	if subscribers[s] {
+		delete(subscribers, s)
+		close(s.send)
+	}
+}
+
+// SetFilter replaces the Subscriber's filter
+func (s *Subscriber) SetFilter(filter format.Filter) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.filter = filter
+}
+
+// Filter returns the Subscriber's current filter
+func (s *Subscriber) Filter() format.Filter {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.filter
+}
+
+// Dropped returns how many changes were dropped because the Subscriber fell behind
+func (s *Subscriber) Dropped() int {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.dropped
+}
+
+// Snapshot returns the current session values matching the Subscriber's filter
+func (s *Subscriber) Snapshot() map[string]Data {
+	filter := s.Filter()
+	output := map[string]Data{}
+	for name, d := range GetAll() {
+		if filter.Matches(name) {
+			output[name] = d
+		}
+	}
+	return output
+}
+
+// publish sends a change to all matching subscribers without waiting on any of them
+func publish(d Data) {
+	subscribersLock.Lock()
+	defer subscribersLock.Unlock()
+
+	for s := range subscribers {
+		if !s.Filter().Matches(d.Name) {
+			continue
+		}
+		select {
+		case s.send <- d:
+		default:
+			s.mutex.Lock()
+			s.dropped++
+			s.mutex.Unlock()
+		}
+	}
+}
+
+// HandleStream upgrades to a WebSocket, and pushes every session change matching the
+// name, prefix and match query parameters. With snapshot=1, matching values are sent first.
+// Clients can change their subscription by sending a JSON filter
+func HandleStream(w http.ResponseWriter, r *http.Request) {
+	filter, err := format.ParseFilter(r.URL.Query())
+	if err != nil {
+		response.WriteNew(&w, r, response.JSONResponse{Output: err.Error(), OK: false})
+		return
+	}
+
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		log.Error().Msgf("Failed to upgrade session stream: %s", err.Error())
+		return
+	}
+	defer conn.Close()
+
+	s := Subscribe(filter, streamBufferSize)
+	defer s.Close()
+	log.Info().Msgf("Opened session stream to %s", r.RemoteAddr)
+
+	// Writes are only made from this goroutine, the reader hands off any snapshot requests
+	snapshots := make(chan bool, 1)
+	if r.URL.Query().Get("snapshot") == "1" {
+		snapshots <- true
+	}
+	done := make(chan bool)
+	go readStream(conn, s, snapshots, done)
+
+	ping := time.NewTicker(30 * time.Second)
+	defer ping.Stop()
+
+	for {
+		var err error
+		select {
+		case d, ok := <-s.C:
+			if !ok {
+				return
+			}
+			err = writeStream(conn, streamMessage{Event: "session", Data: d})
+		case <-snapshots:
+			err = writeStream(conn, streamMessage{Event: "snapshot", Data: s.Snapshot()})
+		case <-ping.C:
+			conn.SetWriteDeadline(time.Now().Add(10 * time.Second))
+			err = conn.WriteMessage(websocket.PingMessage, nil)
+		case <-done:
+			log.Info().Msgf("Closed session stream to %s, %d changes were dropped", r.RemoteAddr, s.Dropped())
+			return
+		}
+
+		if err != nil {
+			log.Error().Msgf("Failed to write to session stream %s: %s", r.RemoteAddr, err.Error())
+			return
+		}
+	}
+}
+
+func writeStream(conn *websocket.Conn, message streamMessage) error {
+	conn.SetWriteDeadline(time.Now().Add(10 * time.Second))
+	return conn.WriteJSON(message)
+}
+
+// readStream handles subscription changes from a stream client, until the client goes away
+func readStream(conn *websocket.Conn, s *Subscriber, snapshots chan bool, done chan bool) {
+	defer close(done)
+
+	conn.SetReadDeadline(time.Now().Add(60 * time.Second))
+	conn.SetPongHandler(func(string) error {
+		return conn.SetReadDeadline(time.Now().Add(60 * time.Second))
+	})
+
+	for {
+		_, message, err := conn.ReadMessage()
+		if err != nil {
+			log.Debug().Msgf("Session stream read ended: %s", err.Error())
+			return
+		}
+		conn.SetReadDeadline(time.Now().Add(60 * time.Second))
+
+		var request streamRequest
+		if err := json.Unmarshal(message, &request); err != nil {
+			log.Error().Msgf("Invalid session stream request: %s", err.Error())
+			continue
+		}
+
+		filter, err := request.Filter.Normalize()
+		if err != nil {
+			log.Error().Msgf("Invalid session stream filter: %s", err.Error())
+			continue
+		}
+		s.SetFilter(filter)
+
+		if request.Snapshot {
+			select {
+			case snapshots <- true:
+			default:
+			}
+		}
+	}
+}
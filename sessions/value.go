@@ -0,0 +1,251 @@
+package sessions
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// ValueType describes the underlying type of a session value
+type ValueType string
+
+const (
+	// TypeBool is a TRUE / FALSE session value
+	TypeBool ValueType = "bool"
+	// TypeInt is a whole number session value
+	TypeInt ValueType = "int"
+	// TypeFloat is a decimal session value
+	TypeFloat ValueType = "float"
+	// TypeString is a plain text session value
+	TypeString ValueType = "string"
+	// TypeObject is a JSON object (or array) session value
+	TypeObject ValueType = "object"
+)
+
+// parseValue coerces an incoming value into one of our supported types.
+// If valueType is empty, the type is inferred from the value itself
+func parseValue(value interface{}, valueType ValueType) (interface{}, ValueType, error) {
+	if value == nil {
+		return nil, "", fmt.Errorf("Value is empty")
+	}
+
+	switch valueType {
+	case "":
+		return inferValue(value)
+	case TypeBool:
+		switch vv := value.(type) {
+		case bool:
+			return vv, TypeBool, nil
+		case string:
+			if b, err := strconv.ParseBool(strings.TrimSpace(vv)); err == nil {
+				return b, TypeBool, nil
+			}
+		}
+	case TypeInt:
+		v, t, err := inferNumber(value)
+		if err != nil {
+			return nil, "", err
+		}
+		switch vv := v.(type) {
+		case int64:
+			return vv, TypeInt, nil
+		case float64:
+			if t == TypeFloat && vv == math.Trunc(vv) {
+				return int64(vv), TypeInt, nil
+			}
+		}
+	case TypeFloat:
+		v, _, err := inferNumber(value)
+		if err != nil {
+			return nil, "", err
+		}
+		switch vv := v.(type) {
+		case int64:
+			return float64(vv), TypeFloat, nil
+		case float64:
+			return vv, TypeFloat, nil
+		}
+	case TypeString:
+		v, _, err := inferValue(value)
+		if err != nil {
+			return nil, "", err
+		}
+		if s, ok := value.(string); ok {
+			return strings.TrimSpace(s), TypeString, nil
+		}
+		return formatValue(v), TypeString, nil
+	case TypeObject:
+		switch vv := value.(type) {
+		case map[string]interface{}, []interface{}:
+			return vv, TypeObject, nil
+		case string:
+			var object interface{}
+			if err := json.Unmarshal([]byte(vv), &object); err == nil {
+				switch object.(type) {
+				case map[string]interface{}, []interface{}:
+					return object, TypeObject, nil
+				}
+			}
+		}
+	default:
+		return nil, "", fmt.Errorf("%s is not a valid session type", valueType)
+	}
+
+	return nil, "", fmt.Errorf("Cannot convert %v to type %s", value, valueType)
+}
+
+// inferNumber is inferValue, but any string that parses as a number is one, i.e. 0123 is 123
+func inferNumber(value interface{}) (interface{}, ValueType, error) {
+	if s, ok := value.(string); ok {
+		if v, t, ok := parseNumber(strings.TrimSpace(s)); ok {
+			return v, t, nil
+		}
+	}
+	return inferValue(value)
+}
+
+// parseNumber parses a string as an int64, or failing that a float64
+func parseNumber(s string) (interface{}, ValueType, bool) {
+	if i, err := strconv.ParseInt(s, 10, 64); err == nil {
+		return i, TypeInt, true
+	}
+	if f, err := strconv.ParseFloat(s, 64); err == nil && !math.IsNaN(f) && !math.IsInf(f, 0) {
+		return f, TypeFloat, true
+	}
+	return nil, "", false
+}
+
+// keepsDigits returns if formatting a number parsed from s gives back the same digits.
+// Trailing zeros after the decimal point are the only ones allowed to be dropped, i.e. 12.50 is 12.5
+func keepsDigits(s string, number interface{}) bool {
+	if strings.Contains(s, ".") {
+		s = strings.TrimRight(strings.TrimRight(s, "0"), ".")
+	}
+	return formatValue(number) == s
+}
+
+// inferValue determines the type of a value, normalizing numbers into int64 or float64.
+// Strings that look like a bool or number are treated as such, unless converting them would change them, i.e. 0123
+func inferValue(value interface{}) (interface{}, ValueType, error) {
+	switch vv := value.(type) {
+	case bool:
+		return vv, TypeBool, nil
+	case int:
+		return int64(vv), TypeInt, nil
+	case int32:
+		return int64(vv), TypeInt, nil
+	case int64:
+		return vv, TypeInt, nil
+	case float32:
+		return float64(vv), TypeFloat, nil
+	case float64:
+		return vv, TypeFloat, nil
+	case json.Number:
+		if i, err := vv.Int64(); err == nil {
+			return i, TypeInt, nil
+		}
+		f, err := vv.Float64()
+		if err != nil {
+			return nil, "", err
+		}
+		return f, TypeFloat, nil
+	case string:
+		s := strings.TrimSpace(vv)
+		switch strings.ToUpper(s) {
+		case "TRUE":
+			return true, TypeBool, nil
+		case "FALSE":
+			return false, TypeBool, nil
+		}
+		if v, t, ok := parseNumber(s); ok && keepsDigits(s, v) {
+			return v, t, nil
+		}
+		return s, TypeString, nil
+	case map[string]interface{}, []interface{}:
+		return vv, TypeObject, nil
+	}
+	return nil, "", fmt.Errorf("%v is of a type I don't know how to handle (%T)", value, value)
+}
+
+// formatValue returns the string representation of a typed value, with bools as TRUE or FALSE
+func formatValue(value interface{}) string {
+	switch vv := value.(type) {
+	case nil:
+		return ""
+	case bool:
+		return strings.ToUpper(strconv.FormatBool(vv))
+	case int64:
+		return strconv.FormatInt(vv, 10)
+	case float64:
+		return strconv.FormatFloat(vv, 'f', -1, 64)
+	case string:
+		return vv
+	}
+
+	out, err := json.Marshal(value)
+	if err != nil {
+		return fmt.Sprintf("%v", value)
+	}
+	return string(out)
+}
+
+// ValueString returns the value as a string, regardless of its type
+func (d Data) ValueString() string {
+	return formatValue(d.Value)
+}
+
+// Bool returns the value if it is a bool
+func (d Data) Bool() (bool, error) {
+	if vb, ok := d.Value.(bool); ok {
+		return vb, nil
+	}
+	return false, d.typeError(TypeBool)
+}
+
+// Int returns the value if it is an int
+func (d Data) Int() (int64, error) {
+	if vi, ok := d.Value.(int64); ok {
+		return vi, nil
+	}
+	return 0, d.typeError(TypeInt)
+}
+
+// Float returns the value if it is a float. Ints are widened to a float
+func (d Data) Float() (float64, error) {
+	switch vv := d.Value.(type) {
+	case float64:
+		return vv, nil
+	case int64:
+		return float64(vv), nil
+	}
+	return 0, d.typeError(TypeFloat)
+}
+
+func (d Data) typeError(expected ValueType) error {
+	return fmt.Errorf("%s is of type %s, not %s", d.Name, d.Type, expected)
+}
+
+// equals compares both the type and value of two session values
+func (d Data) equals(other Data) bool {
+	return d.Type == other.Type && d.ValueString() == other.ValueString()
+}
+
+// publishValue is the JSON encoded value, so type is kept when sent through MQTT
+func (d Data) publishValue() string {
+	out, err := json.Marshal(d.Value)
+	if err != nil {
+		return d.ValueString()
+	}
+	return string(out)
+}
+
+// dbValue is the value in a form our databases can write as a field.
+// Bools are kept as "TRUE"/"FALSE" strings, so existing Influx series don't get a conflicting field type
+func (d Data) dbValue() interface{} {
+	if d.Type == TypeObject || d.Type == TypeBool {
+		return d.ValueString()
+	}
+	return d.Value
+}
@@ -0,0 +1,141 @@
+package sessions
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/graphql-go/graphql/language/ast"
+)
+
+func TestParseValue(t *testing.T) {
+	tables := []struct {
+		input      interface{}
+		inputType  ValueType
+		output     string
+		outputType ValueType
+		fails      bool
+	}{
+		{"TRUE", "", "TRUE", TypeBool, false},
+		{"false", "", "FALSE", TypeBool, false},
+		{true, "", "TRUE", TypeBool, false},
+		{"12", "", "12", TypeInt, false},
+		{json.Number("12"), "", "12", TypeInt, false},
+		{json.Number("12.5"), "", "12.5", TypeFloat, false},
+		{" 12.50 ", "", "12.5", TypeFloat, false},
+		{12.5, "", "12.5", TypeFloat, false},
+		{"KEY_IN", "", "KEY_IN", TypeString, false},
+		{"NAN", "", "NAN", TypeString, false},
+		{"0123", "", "0123", TypeString, false},
+		{"+5", "", "+5", TypeString, false},
+		{"1e3", "", "1e3", TypeString, false},
+		{"0.5", "", "0.5", TypeFloat, false},
+		{"-3", "", "-3", TypeInt, false},
+		{"0123", TypeInt, "123", TypeInt, false},
+		{"12", TypeString, "12", TypeString, false},
+		{"12", TypeFloat, "12", TypeFloat, false},
+		{"12.0", TypeInt, "12", TypeInt, false},
+		{"12.5", TypeInt, "", "", true},
+		{"1", TypeBool, "TRUE", TypeBool, false},
+		{"RAIN", TypeBool, "", "", true},
+		{`{"a":1}`, TypeObject, `{"a":1}`, TypeObject, false},
+		{map[string]interface{}{"a": "b"}, "", `{"a":"b"}`, TypeObject, false},
+		{"12", "date", "", "", true},
+		{nil, "", "", "", true},
+	}
+
+	for _, table := range tables {
+		value, valueType, err := parseValue(table.input, table.inputType)
+		if table.fails {
+			if err == nil {
+				t.Errorf("parseValue(%v, %s) did not fail", table.input, table.inputType)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("parseValue(%v, %s) failed: %s", table.input, table.inputType, err.Error())
+			continue
+		}
+		if got := formatValue(value); got != table.output || valueType != table.outputType {
+			t.Errorf("parseValue(%v, %s) = %s (%s); want %s (%s)", table.input, table.inputType, got, valueType, table.output, table.outputType)
+		}
+	}
+}
+
+func TestTypedGetters(t *testing.T) {
+	d := Data{Name: "MAIN_VOLTAGE", Value: int64(12), Type: TypeInt}
+	if f, err := d.Float(); err != nil || f != 12 {
+		t.Errorf("Float() = %f, %v; want 12", f, err)
+	}
+	if _, err := d.Bool(); err == nil {
+		t.Errorf("Bool() on an int did not fail")
+	}
+
+	d = Data{Name: "MAIN_VOLTAGE", Value: 12.5, Type: TypeFloat}
+	if _, err := d.Int(); err == nil {
+		t.Errorf("Int() on a float did not fail")
+	}
+}
+
+func TestParseLiteral(t *testing.T) {
+	field := func(name string, value ast.Value) *ast.ObjectField {
+		return ast.NewObjectField(&ast.ObjectField{Name: ast.NewName(&ast.Name{Value: name}), Value: value})
+	}
+	tables := []struct {
+		literal ast.Value
+		output  string
+		fails   bool
+	}{
+		{ast.NewObjectValue(&ast.ObjectValue{Fields: []*ast.ObjectField{
+			field("speed", ast.NewIntValue(&ast.IntValue{Value: "12"})),
+			field("on", ast.NewBooleanValue(&ast.BooleanValue{Value: true})),
+		}}), `{"on":true,"speed":12}`, false},
+		{ast.NewListValue(&ast.ListValue{Values: []ast.Value{
+			ast.NewFloatValue(&ast.FloatValue{Value: "1.5"}),
+			ast.NewStringValue(&ast.StringValue{Value: "a"}),
+		}}), `[1.5,"a"]`, false},
+		{ast.NewVariable(&ast.Variable{Name: ast.NewName(&ast.Name{Value: "v"})}), "", true},
+	}
+
+	for _, table := range tables {
+		literal := valueScalar.ParseLiteral(table.literal)
+		if err := literalError(literal); err != nil {
+			if !table.fails {
+				t.Errorf("ParseLiteral(%s) failed: %s", table.literal.GetKind(), err.Error())
+			}
+			continue
+		}
+		if table.fails {
+			t.Errorf("ParseLiteral(%s) = %v; want an error", table.literal.GetKind(), literal)
+			continue
+		}
+		value, _, err := parseValue(literal, "")
+		if got := formatValue(value); err != nil || got != table.output {
+			t.Errorf("ParseLiteral(%s) = %s, %v; want %s", table.literal.GetKind(), got, err, table.output)
+		}
+	}
+}
+
+func TestDBValue(t *testing.T) {
+	tables := []struct {
+		input  interface{}
+		output interface{}
+	}{
+		{true, "TRUE"},
+		{false, "FALSE"},
+		{map[string]interface{}{"a": 1}, `{"a":1}`},
+		{int64(12), int64(12)},
+		{"on", "on"},
+	}
+
+	for _, table := range tables {
+		value, valueType, err := parseValue(table.input, "")
+		if err != nil {
+			t.Errorf("parseValue(%v) failed: %s", table.input, err.Error())
+			continue
+		}
+		d := Data{Name: "DB_VALUE_TEST", Value: value, Type: valueType}
+		if got := d.dbValue(); got != table.output {
+			t.Errorf("dbValue(%v) = %v; want %v", table.input, got, table.output)
+		}
+	}
+}
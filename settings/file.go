@@ -5,41 +5,102 @@ import (
 	"fmt"
 	"io/ioutil"
 	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
 
 	"github.com/rs/zerolog/log"
 )
 
+const (
+	// defaultBackups is used when SETTINGS_BACKUPS is not set in the MDROID config
+	defaultBackups = 3
+	// writeDelay coalesces bursts of sets into a single write
+	writeDelay = time.Second
+)
+
+// pendingWrite tracks a coalesced write that hasn't happened yet
+var pendingWrite struct {
+	timer *time.Timer
+	mutex sync.Mutex
+}
+
+// writeLock keeps rotating backups and writes from overlapping
+var writeLock sync.Mutex
+
 // ReadFile will handle the initialization of settings,
-// either from past mapping or by creating a new one
+// either from past mapping or by creating a new one.
+// If the file is missing or corrupt, the newest valid backup is used instead
 func ReadFile(useSettingsFile string) {
 	log.Info().Msg("Checking settings file...")
 	if useSettingsFile == "" {
-		log.Warn().Msg("Failed to load settings from file '" + Settings.File + "'. Is it empty?")
+		log.Warn().Msg("Failed to load settings from file '" + useSettingsFile + "'. Is it empty?")
 		return
 	}
 
-	Settings.File = useSettingsFile
-	initSettings, err := parseFile(Settings.File)
+	file := useSettingsFile
+	initSettings, err := parseFile(file)
 
+	recovered := false
 	if err != nil || initSettings == nil || len(initSettings) == 0 {
-		panic("Failed to load settings from file '" + Settings.File + "'. Is it empty?")
+		var backup string
+		initSettings, backup = readBackup(file)
+		if initSettings == nil {
+			panic("Failed to load settings from file '" + file + "' or any backup. Is it empty?")
+		}
+		log.Error().Msg("!!! Settings file '" + file + "' is missing or corrupt, recovered settings from backup '" + backup + "' !!!")
+		recovered = true
 	}
 
-	// Set new settings globally
+	// Set new settings globally, with defaults for any declared settings missing
+	Settings.mutex.Lock()
+	Settings.File = file
 	Settings.Data = initSettings
+	applyDefaults(Settings.Data)
+	Settings.mutex.Unlock()
 
-	// Run hooks on all new settings
-	if out, err := json.Marshal(Settings.Data); err == nil {
-		log.Info().Msg("Successfully loaded settings from file '" + Settings.File + "': " + string(out))
-		for component := range Settings.Data {
-			for setting := range Settings.Data[component] {
-				runHooks(component, setting, Settings.Data[component][setting])
+	// Repair the settings file from the backup
+	if recovered {
+		scheduleWrite()
+	}
+	readHistory(file)
+
+	// Run hooks on all new settings, as overlaid by the active profile
+	Settings.mutex.RLock()
+	loaded := effective(Settings.Data)
+	Settings.mutex.RUnlock()
+	if out, err := json.Marshal(loaded); err == nil {
+		log.Info().Msg("Successfully loaded settings from file '" + file + "': " + string(out))
+		for component := range loaded {
+			for setting := range loaded[component] {
+				runHooks(component, setting, loaded[component][setting])
 			}
 		}
 	}
 	return
 }
 
+// backupName returns the name of the nth newest backup of a settings file, starting at 1
+func backupName(file string, n int) string {
+	return fmt.Sprintf("%s.%d", file, n)
+}
+
+// readBackup returns the settings from the newest valid backup, and which backup it was
+func readBackup(file string) (map[string]map[string]string, string) {
+	for n := 1; ; n++ {
+		backup := backupName(file, n)
+		if _, err := os.Stat(backup); err != nil {
+			return nil, ""
+		}
+
+		data, err := parseFile(backup)
+		if err == nil && len(data) > 0 {
+			return data, backup
+		}
+	}
+}
+
 // parseFile will open and interpret program settings,
 // as well as return the generic settings from last session
 func parseFile(filename string) (map[string]map[string]string, error) {
@@ -62,7 +123,106 @@ func parseFile(filename string) (map[string]map[string]string, error) {
 	return data, nil
 }
 
-// writeFile to given file, TODO: create one if it doesn't exist
+// scheduleWrite writes the settings file writeDelay after the first of a burst of sets,
+// along with every other set made before then
+func scheduleWrite() {
+	pendingWrite.mutex.Lock()
+	defer pendingWrite.mutex.Unlock()
+
+	if pendingWrite.timer != nil {
+		return
+	}
+	pendingWrite.timer = time.AfterFunc(writeDelay, func() {
+		Flush()
+	})
+}
+
+// Flush writes any pending settings changes to the settings file now
+func Flush() error {
+	pendingWrite.mutex.Lock()
+	if pendingWrite.timer == nil {
+		pendingWrite.mutex.Unlock()
+		return nil
+	}
+	pendingWrite.timer.Stop()
+	pendingWrite.timer = nil
+	pendingWrite.mutex.Unlock()
+
+	return writeFile(settingsFile())
+}
+
+// settingsFile returns the name of the settings file, empty if none was read
+func settingsFile() string {
+	Settings.mutex.RLock()
+	defer Settings.mutex.RUnlock()
+	return Settings.File
+}
+
+// getBackups returns how many backups of the settings file to keep, from SETTINGS_BACKUPS in the MDROID config
+func getBackups() int {
+	value, err := Get("MDROID", "SETTINGS_BACKUPS")
+	if err != nil {
+		return defaultBackups
+	}
+
+	backups, err := strconv.Atoi(value)
+	if err != nil || backups < 0 {
+		log.Error().Msgf("Invalid SETTINGS_BACKUPS %s, defaulting to %d", value, defaultBackups)
+		return defaultBackups
+	}
+	return backups
+}
+
+// rotateBackups shifts each backup of the settings file one older, and makes the current file the newest.
+// A corrupt settings file is never kept as a backup
+func rotateBackups(file string, backups int) error {
+	if backups == 0 {
+		return nil
+	}
+	if _, err := os.Stat(file); os.IsNotExist(err) {
+		return nil
+	}
+	if data, err := parseFile(file); err != nil || len(data) == 0 {
+		return nil
+	}
+
+	for n := backups - 1; n >= 1; n-- {
+		if err := os.Rename(backupName(file, n), backupName(file, n+1)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return os.Rename(file, backupName(file, 1))
+}
+
+// writeTemp writes and syncs contents to a temporary file next to the given file, returning its name
+func writeTemp(file string, contents []byte) (string, error) {
+	tmp, err := ioutil.TempFile(filepath.Dir(file), filepath.Base(file)+".tmp")
+	if err != nil {
+		return "", err
+	}
+
+	if _, err = tmp.Write(contents); err == nil {
+		err = tmp.Sync()
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	return tmp.Name(), err
+}
+
+// replace renames a temporary file over the given file, making sure the rename itself survives a power cut
+func replace(tmp string, file string) error {
+	if err := os.Rename(tmp, file); err != nil {
+		return err
+	}
+	if dir, err := os.Open(filepath.Dir(file)); err == nil {
+		dir.Sync()
+		dir.Close()
+	}
+	return nil
+}
+
+// writeFile to given file, through a temporary file so a power cut can't leave it half written
 func writeFile(file string) error {
 	if file == "" {
 		return fmt.Errorf("Empty filename")
@@ -77,10 +237,24 @@ func writeFile(file string) error {
 		return err
 	}
 
-	if err = ioutil.WriteFile(file, settingsJSON, 0644); err != nil {
+	writeLock.Lock()
+	defer writeLock.Unlock()
+
+	tmp, err := writeTemp(file, settingsJSON)
+	if tmp != "" {
+		defer os.Remove(tmp)
+	}
+	if err == nil {
+		err = rotateBackups(file, getBackups())
+	}
+	if err == nil {
+		err = replace(tmp, file)
+	}
+	if err != nil {
 		log.Error().Msg("Failed to write Settings to " + file + ": " + err.Error())
 		return err
 	}
+	setLastWrite(settingsJSON)
 
 	// Log success
 	log.Info().Msg("Successfully wrote Settings to " + file)
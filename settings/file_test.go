@@ -0,0 +1,67 @@
+package settings
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// useFile points tests at a settings file, first writing out any sets pending from earlier tests.
+// Tests that set anything should defer Flush, so nothing is written after their file is removed
+func useFile(file string) {
+	Flush()
+	Settings.mutex.Lock()
+	Settings.File = file
+	Settings.mutex.Unlock()
+}
+
+func TestWriteFileBackups(t *testing.T) {
+	dir, err := ioutil.TempDir("", "settings")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "settings.json")
+
+	useFile(file)
+	for _, value := range []string{"1", "2", "3", "4", "5"} {
+		Settings.mutex.Lock()
+		Settings.Data = map[string]map[string]string{"MDROID": {"SETTINGS_BACKUPS": "2", "VALUE": value}}
+		Settings.mutex.Unlock()
+		if err := writeFile(file); err != nil {
+			t.Fatalf("writeFile returned error %s", err.Error())
+		}
+	}
+
+	tables := []struct {
+		file  string
+		value string
+	}{
+		{file, "5"},
+		{backupName(file, 1), "4"},
+		{backupName(file, 2), "3"},
+		{backupName(file, 3), ""},
+	}
+	for _, table := range tables {
+		data, _ := parseFile(table.file)
+		if data["MDROID"]["VALUE"] != table.value {
+			t.Errorf("%s has VALUE %s; want %s", table.file, data["MDROID"]["VALUE"], table.value)
+		}
+	}
+
+	// A truncated settings file falls back to the newest backup, and is repaired
+	if err := ioutil.WriteFile(file, []byte(`{"MDROID": {"VAL`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	ReadFile(file)
+	if value, _ := Get("MDROID", "VALUE"); value != "4" {
+		t.Errorf("Recovered VALUE %s; want 4", value)
+	}
+	if err := Flush(); err != nil {
+		t.Errorf("Flush returned error %s", err.Error())
+	}
+	if data, _ := parseFile(file); data["MDROID"]["VALUE"] != "4" {
+		t.Errorf("Repaired settings file has VALUE %s; want 4", data["MDROID"]["VALUE"])
+	}
+}
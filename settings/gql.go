@@ -34,7 +34,10 @@ var settingType = graphql.NewObject(
 			},
 			"lastUpdate": &graphql.Field{
 				Type:        graphql.String,
-				Description: "UTC Time when inserted",
+				Description: "Time last changed while running",
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					return p.Source.(Setting).LastUpdated, nil
+				},
 			},
 		},
 	},
@@ -56,7 +59,47 @@ var SettingMutation = &graphql.Field{
 		},
 	},
 	Resolve: func(params graphql.ResolveParams) (interface{}, error) {
-		return Set(params.Args["component"].(string), params.Args["setting"].(string), params.Args["value"].(string)), nil
+		if err := SetFrom(params.Args["component"].(string), params.Args["setting"].(string), params.Args["value"].(string), OriginGraphQL); err != nil {
+			return false, err
+		}
+		return true, nil
+	},
+}
+
+// DeleteMutation is a GraphQL schema for setting DELETE requests
+var DeleteMutation = &graphql.Field{
+	Type:        graphql.Boolean,
+	Description: "Delete a setting",
+	Args: graphql.FieldConfigArgument{
+		"component": &graphql.ArgumentConfig{
+			Type: graphql.NewNonNull(graphql.String),
+		},
+		"setting": &graphql.ArgumentConfig{
+			Type: graphql.NewNonNull(graphql.String),
+		},
+	},
+	Resolve: func(params graphql.ResolveParams) (interface{}, error) {
+		if err := DeleteFrom(params.Args["component"].(string), params.Args["setting"].(string), OriginGraphQL); err != nil {
+			return false, err
+		}
+		return true, nil
+	},
+}
+
+// DeleteComponentMutation is a GraphQL schema for deleting every setting of a component
+var DeleteComponentMutation = &graphql.Field{
+	Type:        graphql.Boolean,
+	Description: "Delete a component and all of its settings",
+	Args: graphql.FieldConfigArgument{
+		"component": &graphql.ArgumentConfig{
+			Type: graphql.NewNonNull(graphql.String),
+		},
+	},
+	Resolve: func(params graphql.ResolveParams) (interface{}, error) {
+		if err := DeleteComponentFrom(params.Args["component"].(string), OriginGraphQL); err != nil {
+			return false, err
+		}
+		return true, nil
 	},
 }
 
@@ -90,10 +133,127 @@ var SettingQuery = &graphql.Field{
 				return nil, err
 			}
 			for name, value := range s {
-				c.Settings = append(c.Settings, Setting{Name: name, Value: value})
+				c.Settings = append(c.Settings, Setting{Component: component, Name: name, Value: value, LastUpdated: LastUpdated(component, name)})
 			}
 			outputList = append(outputList, c)
 		}
 		return outputList, nil
 	},
 }
+
+var hookType = graphql.NewObject(
+	graphql.ObjectConfig{
+		Name: "SettingHook",
+		Fields: graphql.Fields{
+			"component": &graphql.Field{
+				Type:        graphql.String,
+				Description: "Component the hook is watching",
+			},
+			"function": &graphql.Field{
+				Type:        graphql.String,
+				Description: "Name of the hook function",
+			},
+			"event": &graphql.Field{
+				Type:        graphql.String,
+				Description: "Event the hook is run on, set or delete",
+			},
+			"priority": &graphql.Field{
+				Type:        graphql.Int,
+				Description: "Higher priority hooks are run first",
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					return p.Source.(HookInfo).Options.Priority, nil
+				},
+			},
+			"sync": &graphql.Field{
+				Type:        graphql.Boolean,
+				Description: "Hook runs before settings are published",
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					return p.Source.(HookInfo).Options.Sync, nil
+				},
+			},
+			"runs": &graphql.Field{
+				Type:        graphql.Int,
+				Description: "Number of times the hook has run",
+			},
+			"failures": &graphql.Field{
+				Type:        graphql.Int,
+				Description: "Number of runs that returned an error or panicked",
+			},
+			"lastRun": &graphql.Field{
+				Type:        graphql.DateTime,
+				Description: "Time the hook last started running",
+			},
+			"lastError": &graphql.Field{
+				Type:        graphql.String,
+				Description: "Most recent error",
+			},
+			"averageDuration": &graphql.Field{
+				Type:        graphql.String,
+				Description: "Average time a run takes",
+			},
+		},
+	},
+)
+
+// HookQuery is a GraphQL schema for listing settings hooks
+var HookQuery = &graphql.Field{
+	Type:        graphql.NewList(hookType),
+	Description: "Get registered settings hooks, by component in the order they're run",
+	Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+		return GetHooks(), nil
+	},
+}
+
+var profileType = graphql.NewObject(
+	graphql.ObjectConfig{
+		Name: "SettingsProfile",
+		Fields: graphql.Fields{
+			"name": &graphql.Field{
+				Type:        graphql.String,
+				Description: "Profile Name",
+			},
+			"active": &graphql.Field{
+				Type:        graphql.Boolean,
+				Description: "Profile is overlaid on the settings",
+			},
+			"settings": &graphql.Field{
+				Type:        graphql.NewList(settingType),
+				Description: "Settings the profile holds, named COMPONENT.SETTING",
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					profile := p.Source.(Profile)
+					var output []Setting
+					for name, value := range profile.Settings {
+						output = append(output, Setting{Component: profilePrefix + profile.Name, Name: name, Value: value})
+					}
+					return output, nil
+				},
+			},
+		},
+	},
+)
+
+// ProfileQuery is a GraphQL schema for listing settings profiles
+var ProfileQuery = &graphql.Field{
+	Type:        graphql.NewList(profileType),
+	Description: "Get settings profiles, and which is active",
+	Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+		return GetProfiles(), nil
+	},
+}
+
+// ProfileMutation is a GraphQL schema for switching settings profiles
+var ProfileMutation = &graphql.Field{
+	Type:        graphql.String,
+	Description: "Activate a settings profile, or NONE to deactivate. Returns the active profile",
+	Args: graphql.FieldConfigArgument{
+		"name": &graphql.ArgumentConfig{
+			Type: graphql.NewNonNull(graphql.String),
+		},
+	},
+	Resolve: func(params graphql.ResolveParams) (interface{}, error) {
+		if err := ActivateProfile(params.Args["name"].(string), OriginGraphQL); err != nil {
+			return nil, err
+		}
+		return GetActiveProfile(), nil
+	},
+}
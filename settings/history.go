@@ -0,0 +1,273 @@
+package settings
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/qcasey/MDroid-Core-Public/format"
+	"github.com/qcasey/MDroid-Core-Public/format/response"
+	"github.com/rs/zerolog/log"
+)
+
+// Origins of settings changes
+const (
+	OriginInternal = "internal" // Set by MDroid itself, i.e. a hook
+	OriginREST     = "rest"
+	OriginGraphQL  = "graphql"
+	OriginMQTT     = "mqtt"
+	OriginFile     = "file" // Edited in the settings file while running
+	OriginRollback = "rollback"
+)
+
+// defaultHistorySize is used when SETTINGS_HISTORY_SIZE is not set in the MDROID config
+const defaultHistorySize = 200
+
+// Change is a single recorded change to a setting
+type Change struct {
+	ID        int       `json:"id"`
+	Time      time.Time `json:"time"`
+	Component string    `json:"component"`
+	Name      string    `json:"name"`
+	OldValue  string    `json:"oldValue,omitempty"`
+	NewValue  string    `json:"newValue,omitempty"`
+	Created   bool      `json:"created,omitempty"` // Setting didn't exist before
+	Removed   bool      `json:"removed,omitempty"` // Setting was removed
+	Origin    string    `json:"origin"`
+}
+
+// history is a bounded journal of settings changes, appended to a file next to the settings file
+var history = struct {
+	changes []Change // Oldest first
+	nextID  int
+	file    string
+	lines   int // Lines in the journal file. It's compacted once it reaches twice the history size
+	mutex   sync.Mutex
+}{nextID: 1}
+
+// getHistorySize returns how many changes to keep, from SETTINGS_HISTORY_SIZE in the MDROID config
+func getHistorySize() int {
+	value, err := Get("MDROID", "SETTINGS_HISTORY_SIZE")
+	if err != nil {
+		return defaultHistorySize
+	}
+
+	size, err := strconv.Atoi(value)
+	if err != nil || size <= 0 {
+		log.Error().Msgf("Invalid SETTINGS_HISTORY_SIZE %s, defaulting to %d", value, defaultHistorySize)
+		return defaultHistorySize
+	}
+	return size
+}
+
+// readHistory loads the journal of changes kept next to the settings file.
+// Lines that can't be read, like one cut short by a power cut, are skipped, and a
+// journal left without a trailing newline is compacted so new changes start on their own line
+func readHistory(settingsFile string) {
+	history.mutex.Lock()
+	defer history.mutex.Unlock()
+
+	history.file = settingsFile + ".history"
+	history.changes = nil
+	history.nextID = 1
+	history.lines = 0
+
+	contents, err := ioutil.ReadFile(history.file)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Error().Msgf("Could not read settings history from '%s': %s", history.file, err.Error())
+		}
+		return
+	}
+
+	scanner := bufio.NewScanner(bytes.NewReader(contents))
+	for scanner.Scan() {
+		history.lines++
+		var change Change
+		if err := json.Unmarshal(scanner.Bytes(), &change); err != nil {
+			log.Warn().Msgf("Skipping unreadable line %d of settings history: %s", history.lines, err.Error())
+			continue
+		}
+		history.changes = append(history.changes, change)
+		if change.ID >= history.nextID {
+			history.nextID = change.ID + 1
+		}
+	}
+
+	if size := getHistorySize(); len(history.changes) > size {
+		history.changes = history.changes[len(history.changes)-size:]
+	}
+	if len(contents) > 0 && contents[len(contents)-1] != '\n' {
+		if err := compactHistory(); err != nil {
+			log.Error().Msgf("Failed to compact settings history '%s': %s", history.file, err.Error())
+		}
+	}
+	log.Info().Msgf("Loaded %d settings changes from '%s'", len(history.changes), history.file)
+}
+
+// record adds a change to the history, and appends it to the journal file
+func record(change Change) {
+	history.mutex.Lock()
+	defer history.mutex.Unlock()
+
+	change.ID = history.nextID
+	change.Time = time.Now()
+	history.nextID++
+
+	size := getHistorySize()
+	history.changes = append(history.changes, change)
+	if len(history.changes) > size {
+		history.changes = history.changes[len(history.changes)-size:]
+	}
+
+	if history.file == "" {
+		return
+	}
+
+	// Rewrite the journal with only the changes kept, once it's grown too large
+	if history.lines+1 >= 2*size {
+		if err := compactHistory(); err != nil {
+			log.Error().Msgf("Failed to compact settings history '%s': %s", history.file, err.Error())
+		}
+		return
+	}
+
+	line, err := json.Marshal(change)
+	if err != nil {
+		log.Error().Msgf("Failed to marshall settings change: %s", err.Error())
+		return
+	}
+	journal, err := os.OpenFile(history.file, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		log.Error().Msgf("Failed to write settings history '%s': %s", history.file, err.Error())
+		return
+	}
+	defer journal.Close()
+
+	if _, err = journal.Write(append(line, '\n')); err != nil {
+		log.Error().Msgf("Failed to write settings history '%s': %s", history.file, err.Error())
+		return
+	}
+	history.lines++
+}
+
+// compactHistory rewrites the journal file with only the changes kept. History must be locked
+func compactHistory() error {
+	var contents bytes.Buffer
+	encoder := json.NewEncoder(&contents)
+	for _, change := range history.changes {
+		if err := encoder.Encode(change); err != nil {
+			return err
+		}
+	}
+
+	tmp, err := writeTemp(history.file, contents.Bytes())
+	if tmp != "" {
+		defer os.Remove(tmp)
+	}
+	if err == nil {
+		err = replace(tmp, history.file)
+	}
+	if err != nil {
+		return err
+	}
+	history.lines = len(history.changes)
+	return nil
+}
+
+// GetHistory returns recorded changes, oldest first, optionally only for a component or setting.
+// A limit above 0 returns only the most recent changes
+func GetHistory(componentName string, settingName string, limit int) []Change {
+	if componentName != "" {
+		componentName = format.Name(componentName)
+	}
+	if settingName != "" {
+		settingName = format.Name(settingName)
+	}
+
+	history.mutex.Lock()
+	defer history.mutex.Unlock()
+
+	output := []Change{}
+	for _, change := range history.changes {
+		if (componentName == "" || change.Component == componentName) && (settingName == "" || change.Name == settingName) {
+			output = append(output, change)
+		}
+	}
+	if limit > 0 && len(output) > limit {
+		output = output[len(output)-limit:]
+	}
+	return output
+}
+
+// Rollback restores the value a setting had before a recorded change, running hooks as any other set.
+// Rolling back the change that created a setting deletes it
+func Rollback(id int) (Setting, error) {
+	history.mutex.Lock()
+	var change Change
+	found := false
+	for _, c := range history.changes {
+		if c.ID == id {
+			change, found = c, true
+			break
+		}
+	}
+	history.mutex.Unlock()
+
+	if !found {
+		return Setting{}, fmt.Errorf("No settings change %d in history", id)
+	}
+	if change.Created {
+		if err := DeleteFrom(change.Component, change.Name, OriginRollback); err != nil {
+			return Setting{}, err
+		}
+		return Setting{Component: change.Component, Name: change.Name}, nil
+	}
+
+	restored := Setting{Component: change.Component, Name: change.Name, Value: change.OldValue}
+	if err := SetFrom(restored.Component, restored.Name, restored.Value, OriginRollback); err != nil {
+		return Setting{}, err
+	}
+	restored.Value, _ = Get(restored.Component, restored.Name)
+	return restored, nil
+}
+
+// HandleGetHistory returns recorded settings changes, oldest first.
+// Optional component, name and limit query parameters narrow them down
+func HandleGetHistory(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	limit := 0
+	if limitString := query.Get("limit"); limitString != "" {
+		var err error
+		if limit, err = strconv.Atoi(limitString); err != nil || limit < 0 {
+			response.WriteNew(&w, r, response.JSONResponse{Output: fmt.Sprintf("Invalid limit %s", limitString), OK: false})
+			return
+		}
+	}
+	response.WriteNew(&w, r, response.JSONResponse{Output: GetHistory(query.Get("component"), query.Get("name"), limit), OK: true})
+}
+
+// HandleRollback restores the value a setting had before a recorded change
+func HandleRollback(w http.ResponseWriter, r *http.Request) {
+	params := mux.Vars(r)
+	id, err := strconv.Atoi(params["id"])
+	if err != nil {
+		response.WriteNew(&w, r, response.JSONResponse{Output: fmt.Sprintf("Invalid change ID %s", params["id"]), OK: false})
+		return
+	}
+
+	restored, err := Rollback(id)
+	if err != nil {
+		response.WriteNew(&w, r, response.JSONResponse{Output: err.Error(), OK: false})
+		return
+	}
+	response.WriteNew(&w, r, response.JSONResponse{Output: restored, OK: true})
+}
@@ -0,0 +1,68 @@
+package settings
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestHistory(t *testing.T) {
+	dir, err := ioutil.TempDir("", "settings")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "settings.json")
+	useFile(file)
+	defer Flush()
+	if err = ioutil.WriteFile(file, []byte(`{"MDROID": {"SETTINGS_HISTORY_SIZE": "3"}, "HISTORY_TEST": {"POWER": "AUTO"}}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	ReadFile(file)
+
+	SetFrom("HISTORY_TEST", "POWER", "ON", OriginREST)
+	SetFrom("HISTORY_TEST", "POWER", "OFF", OriginMQTT)
+	Set("HISTORY_TEST", "OTHER", "1")
+
+	changes := GetHistory("HISTORY_TEST", "POWER", 0)
+	if len(changes) != 2 || changes[1].OldValue != "ON" || changes[1].NewValue != "OFF" || changes[1].Origin != OriginMQTT {
+		t.Fatalf("GetHistory = %+v; want AUTO to ON from rest, then ON to OFF from mqtt", changes)
+	}
+
+	// Undo the change to OFF
+	restored, err := Rollback(changes[1].ID)
+	if err != nil || restored.Value != "ON" {
+		t.Errorf("Rollback(%d) = %+v, %v; want POWER back to ON", changes[1].ID, restored, err)
+	}
+	// Undoing the creation of a setting deletes it
+	if _, err = Rollback(GetHistory("HISTORY_TEST", "OTHER", 0)[0].ID); err != nil {
+		t.Errorf("Rollback of a new setting returned %v", err)
+	}
+	if value, err := Get("HISTORY_TEST", "OTHER"); err == nil {
+		t.Errorf("Get(HISTORY_TEST, OTHER) = %s after rolling back its creation; want it deleted", value)
+	}
+
+	// Only the most recent changes are kept, and they survive a restart, even after a power cut mid line
+	journal, _ := os.OpenFile(file+".history", os.O_APPEND|os.O_WRONLY, 0644)
+	journal.WriteString(`{"id": 99, "comp`)
+	journal.Close()
+	readHistory(file)
+
+	changes = GetHistory("", "", 0)
+	if len(changes) != 3 || changes[1].Origin != OriginRollback || changes[1].NewValue != "ON" || changes[2].Origin != OriginRollback || !changes[2].Removed {
+		t.Errorf("GetHistory after restart = %+v; want the last 3 changes, ending with both rollbacks", changes)
+	}
+	if limited := GetHistory("", "", 1); len(limited) != 1 || limited[0].ID != changes[2].ID {
+		t.Errorf("GetHistory with limit 1 = %+v; want only the last rollback", limited)
+	}
+
+	// Changes appended after the cut short line are read back too. The history is grown so they aren't compacted
+	Set("MDROID", "SETTINGS_HISTORY_SIZE", "100")
+	Set("HISTORY_TEST", "POWER", "AUTO")
+	readHistory(file)
+	changes = GetHistory("", "", 2)
+	if len(changes) != 2 || changes[0].Name != "SETTINGS_HISTORY_SIZE" || changes[1].NewValue != "AUTO" {
+		t.Errorf("GetHistory after more changes and another restart = %+v; want the history resized, then POWER set to AUTO", changes)
+	}
+}
@@ -1,13 +1,62 @@
 package settings
 
 import (
+	"fmt"
+	"sort"
 	"sync"
+	"time"
 
+	"github.com/qcasey/MDroid-Core-Public/format"
 	"github.com/rs/zerolog/log"
 )
 
+// HookFunc is run with a changed setting. Returned errors are logged and counted,
+// and from Sync hooks, stop the setting from changing
+type HookFunc func(settingName string, settingValue string) error
+
+// DeleteHookFunc is run with a deleted setting, it's otherwise treated as a HookFunc
+type DeleteHookFunc func(settingName string) error
+
+// Events hooks are run on
+const (
+	EventSet    = "set"
+	EventDelete = "delete"
+)
+
+// HookOptions control when a hook is run. The zero value runs the hook in the background
+type HookOptions struct {
+	Priority int  `json:"priority,omitempty"` // Higher priority hooks are run (or started) first
+	Sync     bool `json:"sync,omitempty"`     // Run before the setting is changed, published or saved
+}
+
+type hook struct {
+	name          string
+	options       HookOptions
+	function      HookFunc       // Run on sets, nil for delete hooks
+	deleted       DeleteHookFunc // Run on deletes, nil for set hooks
+	runs          int
+	failures      int
+	lastRun       time.Time
+	lastError     string
+	totalDuration time.Duration
+	mutex         sync.Mutex
+}
+
+// HookInfo describes a registered hook, and how it's been running
+type HookInfo struct {
+	Component       string      `json:"component"`
+	Function        string      `json:"function"`
+	Event           string      `json:"event"`
+	Options         HookOptions `json:"options"`
+	Runs            int         `json:"runs"`
+	Failures        int         `json:"failures"`
+	LastRun         time.Time   `json:"lastRun"`
+	LastError       string      `json:"lastError,omitempty"` // Most recent error, even if later runs succeeded
+	AverageDuration string      `json:"averageDuration"`
+}
+
 type hooks struct {
-	list  map[string][]func(settingName string, settingValue string)
+	list  map[string][]*hook
 	count int
 	mutex sync.Mutex
 }
@@ -15,30 +64,178 @@ type hooks struct {
 var hookList hooks
 
 func init() {
-	hookList = hooks{list: make(map[string][]func(settingName string, settingValue string), 0), count: 0}
+	hookList = hooks{list: make(map[string][]*hook, 0), count: 0}
 }
 
 // RegisterHook adds a new hook into a settings change
-func RegisterHook(componentName string, hook func(settingName string, settingValue string)) {
+func RegisterHook(componentName string, function HookFunc) {
+	RegisterHookWithOptions(componentName, HookOptions{}, function)
+}
+
+// RegisterHookWithOptions adds a new hook into a settings change, run according to options
+func RegisterHookWithOptions(componentName string, options HookOptions, function HookFunc) {
 	log.Info().Msgf("Adding new hook for %s", componentName)
+	addHook(componentName, &hook{name: format.FuncName(function), options: options, function: function})
+}
+
+// RegisterDeleteHook adds a new hook into settings being deleted, run according to options
+func RegisterDeleteHook(componentName string, options HookOptions, function DeleteHookFunc) {
+	log.Info().Msgf("Adding new delete hook for %s", componentName)
+	addHook(componentName, &hook{name: format.FuncName(function), options: options, deleted: function})
+}
+
+func addHook(componentName string, h *hook) {
 	hookList.mutex.Lock()
 	defer hookList.mutex.Unlock()
-	hookList.list[componentName] = append(hookList.list[componentName], hook)
+
+	// Keep hooks in order of priority, then registration
+	componentHooks := append(hookList.list[componentName], h)
+	sort.SliceStable(componentHooks, func(i, j int) bool { return componentHooks[i].options.Priority > componentHooks[j].options.Priority })
+	hookList.list[componentName] = componentHooks
 	hookList.count++
 }
 
-// Runs all hooks registered with a specific component name
-func runHooks(componentName string, settingName string, settingValue string) {
+// GetHooks lists every registered hook by component, in the order they're run, and how they've been running
+func GetHooks() []HookInfo {
 	hookList.mutex.Lock()
 	defer hookList.mutex.Unlock()
-	allHooks, ok := hookList.list[componentName]
 
-	if !ok || len(allHooks) == 0 {
-		// No hooks registered for component
-		return
+	componentNames := make([]string, 0, len(hookList.list))
+	for componentName := range hookList.list {
+		componentNames = append(componentNames, componentName)
 	}
+	sort.Strings(componentNames)
+
+	var output []HookInfo
+	for _, componentName := range componentNames {
+		for _, h := range hookList.list[componentName] {
+			h.mutex.Lock()
+			info := HookInfo{
+				Component: componentName,
+				Function:  h.name,
+				Event:     EventSet,
+				Options:   h.options,
+				Runs:      h.runs,
+				Failures:  h.failures,
+				LastRun:   h.lastRun,
+				LastError: h.lastError,
+			}
+			if h.deleted != nil {
+				info.Event = EventDelete
+			}
+			if h.runs > 0 {
+				info.AverageDuration = (h.totalDuration / time.Duration(h.runs)).String()
+			}
+			h.mutex.Unlock()
+			output = append(output, info)
+		}
+	}
+	return output
+}
+
+// run calls the hook, recovering from any panic, and counts the outcome
+func (h *hook) run(componentName string, settingName string, call func() error) (err error) {
+	start := time.Now()
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("panic: %v", r)
+		}
+
+		h.mutex.Lock()
+		h.runs++
+		h.lastRun = start
+		h.totalDuration += time.Since(start)
+		if err != nil {
+			h.failures++
+			h.lastError = err.Error()
+		}
+		h.mutex.Unlock()
+
+		if err != nil {
+			log.Error().Msgf("Hook %s failed on %s[%s]: %s", h.name, componentName, settingName, err.Error())
+		}
+	}()
+	return call()
+}
+
+// Runs all hooks registered with a specific component name. Sync hooks are run first, in order
+// of priority, and the first error stops the setting going any further. The rest are started in the background
+func runHooks(componentName string, settingName string, settingValue string) error {
+	return runEvent(componentName, settingName, EventSet, setCall(settingValue))
+}
+
+// runSyncHooks runs only the sync hooks for a setting, so it can be rejected before it's changed
+func runSyncHooks(componentName string, settingName string, settingValue string) error {
+	return runSync(eventHooks(componentName, EventSet), componentName, settingName, setCall(settingValue))
+}
+
+// startHooks starts the hooks for a setting that aren't sync in the background, once it's changed
+func startHooks(componentName string, settingName string, settingValue string) {
+	startBackground(eventHooks(componentName, EventSet), componentName, settingName, setCall(settingValue))
+}
+
+// runSyncDeleteHooks runs only the sync delete hooks for a setting, so its deletion can be rejected before it's removed
+func runSyncDeleteHooks(componentName string, settingName string) error {
+	return runSync(eventHooks(componentName, EventDelete), componentName, settingName, deleteCall)
+}
+
+// startDeleteHooks starts the delete hooks for a setting that aren't sync in the background, once it's removed
+func startDeleteHooks(componentName string, settingName string) {
+	startBackground(eventHooks(componentName, EventDelete), componentName, settingName, deleteCall)
+}
+
+func setCall(settingValue string) func(h *hook, settingName string) error {
+	return func(h *hook, settingName string) error { return h.function(settingName, settingValue) }
+}
+
+func deleteCall(h *hook, settingName string) error {
+	return h.deleted(settingName)
+}
+
+// runEvent runs each hook for an event with call, sync hooks first
+func runEvent(componentName string, settingName string, event string, call func(h *hook, settingName string) error) error {
+	allHooks := eventHooks(componentName, event)
+	if err := runSync(allHooks, componentName, settingName, call); err != nil {
+		return err
+	}
+	startBackground(allHooks, componentName, settingName, call)
+	return nil
+}
+
+// eventHooks returns the hooks registered with a component for an event, in the order they're run
+func eventHooks(componentName string, event string) []*hook {
+	hookList.mutex.Lock()
+	defer hookList.mutex.Unlock()
+
+	var allHooks []*hook
+	for _, h := range hookList.list[componentName] {
+		if (event == EventDelete) == (h.deleted != nil) {
+			allHooks = append(allHooks, h)
+		}
+	}
+	return allHooks
+}
+
+// runSync runs each sync hook in order, returning the first error.
+// Hooks can change settings themselves, so none are run while holding the hook lock
+func runSync(allHooks []*hook, componentName string, settingName string, call func(h *hook, settingName string) error) error {
+	for _, h := range allHooks {
+		if !h.options.Sync {
+			continue
+		}
+		if err := h.run(componentName, settingName, func() error { return call(h, settingName) }); err != nil {
+			return fmt.Errorf("Hook %s rejected %s[%s]: %s", h.name, componentName, settingName, err.Error())
+		}
+	}
+	return nil
+}
 
+// startBackground starts each hook that isn't sync
+func startBackground(allHooks []*hook, componentName string, settingName string, call func(h *hook, settingName string) error) {
 	for _, h := range allHooks {
-		go h(settingName, settingValue)
+		if !h.options.Sync {
+			h := h
+			go h.run(componentName, settingName, func() error { return call(h, settingName) })
+		}
 	}
 }
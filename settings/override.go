@@ -0,0 +1,55 @@
+package settings
+
+import (
+	"fmt"
+
+	"github.com/qcasey/MDroid-Core-Public/format"
+)
+
+// Override holds a setting at a value for as long as MDroid is running, i.e. from the command line or environment.
+// Overrides take precedence over the active profile and the settings file, and are never saved.
+// They should be set before the settings file is read, so hooks first run with them
+func Override(componentName string, settingName string, settingValue string) error {
+	componentName = format.Name(componentName)
+	settingName = format.Name(settingName)
+
+	settingValue, err := normalize(componentName, settingName, settingValue)
+	if err != nil {
+		return fmt.Errorf("Invalid override for setting %s[%s]: %s", componentName, settingName, err.Error())
+	}
+
+	Settings.mutex.Lock()
+	defer Settings.mutex.Unlock()
+	if _, ok := Settings.overrides[componentName]; !ok {
+		Settings.overrides[componentName] = make(map[string]string)
+	}
+	Settings.overrides[componentName][settingName] = settingValue
+	return nil
+}
+
+// IsOverridden returns if a setting is held by an override
+func IsOverridden(componentName string, settingName string) bool {
+	Settings.mutex.RLock()
+	defer Settings.mutex.RUnlock()
+	_, ok := Settings.overrides[format.Name(componentName)][format.Name(settingName)]
+	return ok
+}
+
+// IsSecret returns if a setting is declared secret, and shouldn't be shown
+func IsSecret(componentName string, settingName string) bool {
+	schema, ok := getSchema(format.Name(componentName), format.Name(settingName))
+	return ok && schema.Secret
+}
+
+// lookup returns a setting's value, from its override, the active profile, or the settings file in that order.
+// Settings must be locked
+func lookup(componentName string, settingName string) (string, bool) {
+	if value, ok := Settings.overrides[componentName][settingName]; ok {
+		return value, true
+	}
+	if value, ok := overlay(Settings.Data, componentName, settingName); ok {
+		return value, true
+	}
+	value, ok := Settings.Data[componentName][settingName]
+	return value, ok
+}
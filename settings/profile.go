@@ -0,0 +1,233 @@
+package settings
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/gorilla/mux"
+	"github.com/qcasey/MDroid-Core-Public/format"
+	"github.com/qcasey/MDroid-Core-Public/format/response"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	// profileSetting is the MDROID setting naming the active profile
+	profileSetting = "PROFILE"
+	// profilePrefix starts the name of each component holding a profile, i.e. PROFILE_VALET
+	profilePrefix = "PROFILE_"
+	// NoProfile is the active profile when none is
+	NoProfile = "NONE"
+)
+
+// Profile is a named overlay on top of the settings, kept in the settings file as a PROFILE_<NAME> component.
+// Its settings are named COMPONENT.SETTING, i.e. {"PROFILE_VALET": {"MDROID.AUTOLOCK": "ON"}}
+type Profile struct {
+	Name     string            `json:"name"`
+	Active   bool              `json:"active"`
+	Settings map[string]string `json:"settings"`
+}
+
+// activeProfile returns the name of the active profile. Settings must be locked
+func activeProfile(data map[string]map[string]string) string {
+	if name, ok := Settings.overrides["MDROID"][profileSetting]; ok {
+		return name
+	}
+	if name, ok := data["MDROID"][profileSetting]; ok && name != "" {
+		return name
+	}
+	return NoProfile
+}
+
+// overlay returns the value the active profile holds a setting at, if it does. Settings must be locked
+func overlay(data map[string]map[string]string, componentName string, settingName string) (string, bool) {
+	active := activeProfile(data)
+	if active == NoProfile {
+		return "", false
+	}
+	value, ok := data[profilePrefix+active][componentName+"."+settingName]
+	return value, ok
+}
+
+// held returns an error if an override or the active profile holds a setting, or the setting is part of the
+// active profile. Settings must be locked
+func held(componentName string, settingName string) error {
+	if _, ok := Settings.overrides[componentName][settingName]; ok {
+		return fmt.Errorf("Setting %s[%s] is overridden from the command line or environment", componentName, settingName)
+	}
+
+	active := activeProfile(Settings.Data)
+	if active == NoProfile {
+		return nil
+	}
+	if _, ok := overlay(Settings.Data, componentName, settingName); ok || componentName == profilePrefix+active {
+		return fmt.Errorf("Setting %s[%s] is held by active profile %s, deactivate it first", componentName, settingName, active)
+	}
+	return nil
+}
+
+// effective copies every setting, as overlaid by the active profile and then overrides. Settings must be locked
+func effective(data map[string]map[string]string) map[string]map[string]string {
+	output := make(map[string]map[string]string, len(data))
+	for componentName, component := range data {
+		output[componentName] = make(map[string]string, len(component))
+		for settingName, value := range component {
+			output[componentName][settingName] = value
+		}
+	}
+
+	if active := activeProfile(data); active != NoProfile {
+		for key, value := range data[profilePrefix+active] {
+			parts := strings.SplitN(key, ".", 2)
+			if len(parts) != 2 {
+				continue
+			}
+			if _, ok := output[parts[0]]; !ok {
+				output[parts[0]] = make(map[string]string)
+			}
+			output[parts[0]][parts[1]] = value
+		}
+	}
+
+	for componentName, component := range Settings.overrides {
+		if _, ok := output[componentName]; !ok {
+			output[componentName] = make(map[string]string)
+		}
+		for settingName, value := range component {
+			output[componentName][settingName] = value
+		}
+	}
+	return output
+}
+
+// GetProfiles returns every profile, and if it's active
+func GetProfiles() []Profile {
+	Settings.mutex.RLock()
+	defer Settings.mutex.RUnlock()
+
+	active := activeProfile(Settings.Data)
+	profiles := []Profile{}
+	for componentName, component := range Settings.Data {
+		name := strings.TrimPrefix(componentName, profilePrefix)
+		if name == componentName {
+			continue
+		}
+
+		profile := Profile{Name: name, Active: name == active, Settings: make(map[string]string, len(component))}
+		for key, value := range component {
+			profile.Settings[key] = value
+		}
+		profiles = append(profiles, profile)
+	}
+	sort.Slice(profiles, func(i, j int) bool { return profiles[i].Name < profiles[j].Name })
+	return profiles
+}
+
+// GetActiveProfile returns the name of the active profile, or NONE
+func GetActiveProfile() string {
+	Settings.mutex.RLock()
+	defer Settings.mutex.RUnlock()
+	return activeProfile(Settings.Data)
+}
+
+// ActivateProfile switches to a profile in one step, or back to the plain settings if name is NONE.
+// Hooks run once for each setting whose value changed
+func ActivateProfile(name string, origin string) error {
+	name = format.Name(name)
+
+	Settings.mutex.Lock()
+	if _, ok := Settings.overrides["MDROID"][profileSetting]; ok {
+		Settings.mutex.Unlock()
+		return fmt.Errorf("Settings profile is overridden from the command line or environment")
+	}
+	if name != NoProfile {
+		profile, ok := Settings.Data[profilePrefix+name]
+		if !ok {
+			Settings.mutex.Unlock()
+			return fmt.Errorf("No settings profile %s", name)
+		}
+
+		// Check the whole profile before any of it is applied
+		normalized := make(map[string]string, len(profile))
+		for key, value := range profile {
+			parts := strings.SplitN(key, ".", 2)
+			if len(parts) != 2 {
+				Settings.mutex.Unlock()
+				return fmt.Errorf("Invalid setting %s in profile %s, expected COMPONENT.SETTING", key, name)
+			}
+			v, err := normalize(parts[0], parts[1], value)
+			if err != nil {
+				Settings.mutex.Unlock()
+				return fmt.Errorf("Invalid value for setting %s in profile %s: %s", key, name, err.Error())
+			}
+			normalized[key] = v
+		}
+		Settings.Data[profilePrefix+name] = normalized
+	}
+	changes := diff(effective(Settings.Data), effective(withProfile(Settings.Data, name)), origin)
+	Settings.mutex.Unlock()
+
+	// Sync hooks can reject any setting the profile changes, like any other set
+	if err := checkChanges(changes); err != nil {
+		return fmt.Errorf("Not activating settings profile %s: %s", name, err.Error())
+	}
+
+	Settings.mutex.Lock()
+	if _, ok := Settings.overrides["MDROID"][profileSetting]; ok {
+		Settings.mutex.Unlock()
+		return fmt.Errorf("Settings profile is overridden from the command line or environment")
+	}
+	before := effective(Settings.Data)
+	if _, ok := Settings.Data["MDROID"]; !ok {
+		Settings.Data["MDROID"] = make(map[string]string)
+	}
+	Settings.Data["MDROID"][profileSetting] = name
+	changes = diff(before, effective(Settings.Data), origin)
+	for _, change := range changes {
+		Settings.updated[change.Component+"/"+change.Name] = change.Time
+	}
+	Settings.mutex.Unlock()
+
+	log.Info().Msgf("Activated settings profile %s, %d settings changed", name, len(changes))
+	announce(changes)
+	scheduleWrite()
+	return nil
+}
+
+// withProfile returns settings as they'd be with a profile active, sharing all but the MDROID component
+func withProfile(data map[string]map[string]string, name string) map[string]map[string]string {
+	output := make(map[string]map[string]string, len(data))
+	for componentName, component := range data {
+		output[componentName] = component
+	}
+	mdroid := make(map[string]string, len(data["MDROID"])+1)
+	for settingName, value := range data["MDROID"] {
+		mdroid[settingName] = value
+	}
+	mdroid[profileSetting] = name
+	output["MDROID"] = mdroid
+	return output
+}
+
+// HandleGetProfiles returns every profile, and which is active
+func HandleGetProfiles(w http.ResponseWriter, r *http.Request) {
+	output := map[string]interface{}{"active": GetActiveProfile(), "profiles": GetProfiles()}
+	response.WriteNew(&w, r, response.JSONResponse{Output: output, OK: true})
+}
+
+// HandleActivateProfile switches to the named profile, or back to the plain settings if it's NONE
+func HandleActivateProfile(w http.ResponseWriter, r *http.Request) {
+	params := mux.Vars(r)
+
+	origin := r.Header.Get(response.SourceHeader)
+	if origin == "" {
+		origin = OriginREST
+	}
+
+	if err := ActivateProfile(params["name"], origin); err != nil {
+		response.WriteNew(&w, r, response.JSONResponse{Output: err.Error(), OK: false})
+		return
+	}
+	response.WriteNew(&w, r, response.JSONResponse{Output: GetActiveProfile(), OK: true})
+}
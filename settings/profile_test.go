@@ -0,0 +1,79 @@
+package settings
+
+import (
+	"fmt"
+	"sort"
+	"testing"
+)
+
+func TestActivateProfile(t *testing.T) {
+	useFile("")
+	defer Flush()
+	Settings.mutex.Lock()
+	Settings.Data["PROFILE_TEST"] = map[string]string{"AUTOLOCK": "OFF", "RADIO": "ON"}
+	Settings.Data["PROFILE_VALET"] = map[string]string{"PROFILE_TEST.AUTOLOCK": "on", "PROFILE_TEST.RADIO": "ON"}
+	Settings.Data["PROFILE_WINTER"] = map[string]string{"PROFILE_TEST.HEATER": "ON"}
+	Settings.Data["PROFILE_BROKEN"] = map[string]string{"AUTOLOCK": "ON"}
+	Settings.Data["PROFILE_REJECTED"] = map[string]string{"PROFILE_TEST.RADIO": "REJECTED"}
+	Settings.mutex.Unlock()
+
+	var changed []string
+	RegisterHookWithOptions("PROFILE_TEST", HookOptions{Sync: true}, func(settingName string, settingValue string) error {
+		changed = append(changed, settingName+"="+settingValue)
+		if settingValue == "REJECTED" {
+			return fmt.Errorf("%s is rejected", settingValue)
+		}
+		return nil
+	})
+
+	tables := []struct {
+		profile string
+		err     bool
+		changed []string
+		active  string
+	}{
+		{"valet", false, []string{"AUTOLOCK=ON"}, "VALET"},
+		{"missing", true, nil, "VALET"},
+		{"broken", true, nil, "VALET"},
+		{"winter", false, []string{"AUTOLOCK=OFF", "HEATER=ON"}, "WINTER"},
+		{"rejected", true, []string{"RADIO=REJECTED"}, "WINTER"},
+		{"none", false, nil, NoProfile},
+	}
+
+	for _, table := range tables {
+		changed = nil
+		err := ActivateProfile(table.profile, OriginREST)
+		if (err != nil) != table.err {
+			t.Errorf("ActivateProfile(%s) returned %v; want error %v", table.profile, err, table.err)
+		}
+		sort.Strings(changed)
+		if len(changed) != len(table.changed) {
+			t.Errorf("ActivateProfile(%s) ran hooks for %v; want %v", table.profile, changed, table.changed)
+		} else {
+			for i := range changed {
+				if changed[i] != table.changed[i] {
+					t.Errorf("ActivateProfile(%s) ran hooks for %v; want %v", table.profile, changed, table.changed)
+					break
+				}
+			}
+		}
+		if active := GetActiveProfile(); active != table.active {
+			t.Errorf("ActivateProfile(%s) left %s active; want %s", table.profile, active, table.active)
+		}
+	}
+
+	// Settings held by a profile can't be set while it's active
+	if err := Set("MDROID", "PROFILE", "valet"); err != nil {
+		t.Fatalf("Set(MDROID, PROFILE, valet) returned %v", err)
+	}
+	if err := Set("PROFILE_TEST", "AUTOLOCK", "OFF"); err == nil {
+		t.Errorf("Set of a setting held by the active profile should have failed")
+	}
+	if value, _ := Get("PROFILE_TEST", "AUTOLOCK"); value != "ON" {
+		t.Errorf("Get(PROFILE_TEST, AUTOLOCK) = %s with VALET active; want ON", value)
+	}
+	ActivateProfile(NoProfile, OriginREST)
+	if value, _ := Get("PROFILE_TEST", "AUTOLOCK"); value != "OFF" {
+		t.Errorf("Get(PROFILE_TEST, AUTOLOCK) = %s after deactivating; want OFF", value)
+	}
+}
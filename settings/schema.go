@@ -0,0 +1,200 @@
+package settings
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/qcasey/MDroid-Core-Public/format"
+	"github.com/qcasey/MDroid-Core-Public/format/response"
+	"github.com/rs/zerolog/log"
+)
+
+// Types of setting values
+const (
+	TypeString = "string"
+	TypeBool   = "bool"
+	TypeInt    = "int"
+	TypeFloat  = "float"
+)
+
+// Schema declares a single setting's type, default and allowed values
+type Schema struct {
+	Type          string   `json:"type,omitempty"`          // string (default), bool, int or float
+	Default       string   `json:"default,omitempty"`       // Used when the setting is missing or invalid on load
+	Allowed       []string `json:"allowed,omitempty"`       // If set, the only values accepted
+	CaseSensitive bool     `json:"caseSensitive,omitempty"` // Keep the value as given, instead of upper casing it
+	Secret        bool     `json:"secret,omitempty"`        // Redacted when config is printed
+	Description   string   `json:"description,omitempty"`
+}
+
+var schemas = struct {
+	components map[string]map[string]Schema
+	mutex      sync.RWMutex
+}{components: make(map[string]map[string]Schema)}
+
+// RegisterSchema declares settings for a component, alongside any declared before.
+// Defaults are applied to settings that are already loaded
+func RegisterSchema(componentName string, settings map[string]Schema) error {
+	componentName = format.Name(componentName)
+
+	schemas.mutex.Lock()
+	if _, ok := schemas.components[componentName]; !ok {
+		schemas.components[componentName] = make(map[string]Schema)
+	}
+	for settingName, schema := range settings {
+		switch schema.Type {
+		case "":
+			schema.Type = TypeString
+		case TypeString, TypeBool, TypeInt, TypeFloat:
+		default:
+			schemas.mutex.Unlock()
+			return fmt.Errorf("%s[%s] has unknown type %s", componentName, settingName, schema.Type)
+		}
+		if !schema.CaseSensitive && len(schema.Allowed) > 0 {
+			allowed := make([]string, len(schema.Allowed))
+			for i, value := range schema.Allowed {
+				allowed[i] = format.Name(value)
+			}
+			schema.Allowed = allowed
+		}
+		if schema.Default != "" {
+			value, err := schema.normalize(schema.Default)
+			if err != nil {
+				schemas.mutex.Unlock()
+				return fmt.Errorf("%s[%s] has an invalid default: %s", componentName, settingName, err.Error())
+			}
+			schema.Default = value
+		}
+		schemas.components[componentName][format.Name(settingName)] = schema
+	}
+	schemas.mutex.Unlock()
+
+	Settings.mutex.Lock()
+	applyDefaults(Settings.Data)
+	Settings.mutex.Unlock()
+	return nil
+}
+
+// GetSchema returns every declared setting, by component
+func GetSchema() map[string]map[string]Schema {
+	schemas.mutex.RLock()
+	defer schemas.mutex.RUnlock()
+
+	output := make(map[string]map[string]Schema, len(schemas.components))
+	for componentName, component := range schemas.components {
+		output[componentName] = make(map[string]Schema, len(component))
+		for settingName, schema := range component {
+			output[componentName][settingName] = schema
+		}
+	}
+	return output
+}
+
+func getSchema(componentName string, settingName string) (Schema, bool) {
+	schemas.mutex.RLock()
+	defer schemas.mutex.RUnlock()
+	schema, ok := schemas.components[componentName][settingName]
+	return schema, ok
+}
+
+// normalize validates a value against its declared schema, returning the value as it should be stored.
+// Undeclared settings are upper cased, like names
+func normalize(componentName string, settingName string, settingValue string) (string, error) {
+	schema, ok := getSchema(componentName, settingName)
+	if !ok {
+		return format.Name(settingValue), nil
+	}
+	return schema.normalize(settingValue)
+}
+
+func (schema Schema) normalize(value string) (string, error) {
+	if !schema.CaseSensitive {
+		value = format.Name(value)
+	}
+
+	switch schema.Type {
+	case TypeBool:
+		v, err := strconv.ParseBool(value)
+		if err != nil {
+			return value, fmt.Errorf("%s is not a bool", value)
+		}
+		value = strings.ToUpper(strconv.FormatBool(v))
+	case TypeInt:
+		v, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return value, fmt.Errorf("%s is not an int", value)
+		}
+		value = strconv.FormatInt(v, 10)
+	case TypeFloat:
+		v, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return value, fmt.Errorf("%s is not a float", value)
+		}
+		value = strconv.FormatFloat(v, 'f', -1, 64)
+	}
+
+	if len(schema.Allowed) > 0 && !format.StringInSlice(value, schema.Allowed) {
+		return value, fmt.Errorf("%s is not one of %s", value, strings.Join(schema.Allowed, ", "))
+	}
+	return value, nil
+}
+
+// applyDefaults fills in declared settings that are missing, and replaces invalid ones with their default.
+// Settings must be locked if data is Settings.Data
+func applyDefaults(data map[string]map[string]string) {
+	schemas.mutex.RLock()
+	defer schemas.mutex.RUnlock()
+
+	for componentName, component := range schemas.components {
+		for settingName, schema := range component {
+			value, exists := data[componentName][settingName]
+			if exists {
+				normalized, err := schema.normalize(value)
+				if err == nil {
+					data[componentName][settingName] = normalized
+					continue
+				}
+				if schema.Default == "" {
+					log.Error().Msgf("Invalid setting %s[%s]: %s", componentName, settingName, err.Error())
+					continue
+				}
+				log.Warn().Msgf("Invalid setting %s[%s]: %s. Using default %s", componentName, settingName, err.Error(), schema.Default)
+			}
+			if schema.Default == "" {
+				continue
+			}
+
+			if _, ok := data[componentName]; !ok {
+				data[componentName] = make(map[string]string, 0)
+			}
+			data[componentName][settingName] = schema.Default
+		}
+	}
+}
+
+// validate checks every declared setting in data against its schema, without changing anything
+func validate(data map[string]map[string]string) error {
+	schemas.mutex.RLock()
+	defer schemas.mutex.RUnlock()
+
+	for componentName, component := range schemas.components {
+		for settingName, schema := range component {
+			value, exists := data[componentName][settingName]
+			if !exists {
+				continue
+			}
+			if _, err := schema.normalize(value); err != nil {
+				return fmt.Errorf("Invalid value for setting %s[%s]: %s", componentName, settingName, err.Error())
+			}
+		}
+	}
+	return nil
+}
+
+// HandleGetSchema returns every declared setting, by component
+func HandleGetSchema(w http.ResponseWriter, r *http.Request) {
+	response.WriteNew(&w, r, response.JSONResponse{Output: GetSchema(), OK: true})
+}
@@ -0,0 +1,52 @@
+package settings
+
+import "testing"
+
+func TestSchema(t *testing.T) {
+	useFile("")
+	defer Flush()
+	Settings.mutex.Lock()
+	Settings.Data = map[string]map[string]string{"SCHEMA_TEST": {"MODE": "sideways", "COUNT": "4"}}
+	Settings.mutex.Unlock()
+	err := RegisterSchema("SCHEMA_TEST", map[string]Schema{
+		"MODE":    {Default: "AUTO", Allowed: []string{"auto", "on", "off"}},
+		"COUNT":   {Type: TypeInt},
+		"ENABLED": {Type: TypeBool, Default: "true"},
+		"URL":     {CaseSensitive: true},
+	})
+	if err != nil {
+		t.Fatalf("RegisterSchema returned error %s", err.Error())
+	}
+
+	// Invalid and missing settings fall back to their defaults
+	for name, want := range map[string]string{"MODE": "AUTO", "COUNT": "4", "ENABLED": "TRUE"} {
+		if value, _ := Get("SCHEMA_TEST", name); value != want {
+			t.Errorf("SCHEMA_TEST[%s] = %s after defaults; want %s", name, value, want)
+		}
+	}
+
+	tables := []struct {
+		name  string
+		value string
+		err   bool
+		want  string
+	}{
+		{"MODE", "on", false, "ON"},
+		{"MODE", "dim", true, "ON"},
+		{"COUNT", "12", false, "12"},
+		{"COUNT", "twelve", true, "12"},
+		{"ENABLED", "0", false, "FALSE"},
+		{"URL", "https://hooks.example.com/Abc Def", false, "https://hooks.example.com/Abc Def"},
+		{"UNDECLARED", "some value", false, "SOME_VALUE"},
+	}
+
+	for _, table := range tables {
+		err := Set("SCHEMA_TEST", table.name, table.value)
+		if (err != nil) != table.err {
+			t.Errorf("Set(%s, %s) returned %v; want error %v", table.name, table.value, err, table.err)
+		}
+		if value, _ := Get("SCHEMA_TEST", table.name); value != table.want {
+			t.Errorf("Set(%s, %s) stored %s; want %s", table.name, table.value, value, table.want)
+		}
+	}
+}
@@ -4,9 +4,12 @@ package settings
 import (
 	"fmt"
 	"net/http"
+	"sort"
 	"strconv"
 	"sync"
+	"time"
 
+	"github.com/qcasey/MDroid-Core-Public/events"
 	"github.com/qcasey/MDroid-Core-Public/format"
 	"github.com/qcasey/MDroid-Core-Public/format/response"
 	"github.com/qcasey/MDroid-Core-Public/mqtt"
@@ -16,13 +19,17 @@ import (
 )
 
 type settingsWrap struct {
-	File  string
-	mutex sync.RWMutex
-	Data  map[string]map[string]string // Main settings map
+	File    string // Settings file, locked like Data once it's read
+	mutex   sync.RWMutex
+	Data    map[string]map[string]string // Main settings map
+	updated map[string]time.Time         // When each COMPONENT/NAME was last changed while running
+
+	overrides map[string]map[string]string // Held from the command line or environment, never saved
 }
 
 // Setting is GraphQL handler struct
 type Setting struct {
+	Component   string `json:"component,omitempty"`
 	Name        string `json:"name,omitempty"`
 	Value       string `json:"value,omitempty"`
 	LastUpdated string `json:"lastUpdated,omitempty"`
@@ -38,7 +45,10 @@ type Component struct {
 var Settings settingsWrap
 
 func init() {
-	Settings = settingsWrap{Data: make(map[string]map[string]string, 0)}
+	Settings = settingsWrap{Data: make(map[string]map[string]string, 0), updated: make(map[string]time.Time), overrides: make(map[string]map[string]string)}
+	RegisterSchema("MDROID", map[string]Schema{
+		profileSetting: {Default: NoProfile, Description: "Active settings profile"},
+	})
 }
 
 // HandleGetAll returns all current settings
@@ -56,7 +66,7 @@ func HandleGet(w http.ResponseWriter, r *http.Request) {
 	log.Debug().Msgf("Responding to GET request for setting component %s", componentName)
 
 	Settings.mutex.RLock()
-	responseVal, ok := Settings.Data[componentName]
+	responseVal, ok := effective(Settings.Data)[componentName]
 	Settings.mutex.RUnlock()
 
 	resp := response.JSONResponse{Output: responseVal, OK: true}
@@ -76,7 +86,7 @@ func HandleGetValue(w http.ResponseWriter, r *http.Request) {
 	log.Debug().Msgf("Responding to GET request for setting %s on component %s", settingName, componentName)
 
 	Settings.mutex.RLock()
-	responseVal, ok := Settings.Data[componentName][settingName]
+	responseVal, ok := lookup(componentName, settingName)
 	Settings.mutex.RUnlock()
 
 	resp := response.JSONResponse{Output: responseVal, OK: true}
@@ -87,19 +97,13 @@ func HandleGetValue(w http.ResponseWriter, r *http.Request) {
 	resp.Write(&w, r)
 }
 
-// GetAll returns all the values of known settings
+// GetAll returns all the values of known settings, as overlaid by the active profile and overrides
 func GetAll() map[string]map[string]string {
 	log.Debug().Msgf("Responding to request for all settings")
 
-	newData := map[string]map[string]string{}
-
 	Settings.mutex.RLock()
 	defer Settings.mutex.RUnlock()
-	for index, element := range Settings.Data {
-		newData[index] = element
-	}
-
-	return newData
+	return effective(Settings.Data)
 }
 
 // GetComponent returns all the values of a specific component
@@ -109,7 +113,7 @@ func GetComponent(componentName string) (map[string]string, error) {
 
 	Settings.mutex.RLock()
 	defer Settings.mutex.RUnlock()
-	component, ok := Settings.Data[componentName]
+	component, ok := effective(Settings.Data)[componentName]
 	if ok {
 		return component, nil
 	}
@@ -121,12 +125,8 @@ func Get(componentName string, settingName string) (string, error) {
 	Settings.mutex.RLock()
 	defer Settings.mutex.RUnlock()
 
-	component, ok := Settings.Data[format.Name(componentName)]
-	if ok {
-		setting, ok := component[settingName]
-		if ok {
-			return setting, nil
-		}
+	if setting, ok := lookup(format.Name(componentName), settingName); ok {
+		return setting, nil
 	}
 	return "", fmt.Errorf("Could not find component/setting with those values")
 }
@@ -157,45 +157,280 @@ func HandleSet(w http.ResponseWriter, r *http.Request) {
 	// Log if requested
 	log.Debug().Msgf("Responding to POST request for setting %s on component %s to be value %s", settingName, componentName, settingValue)
 
+	// Record where the change came from, requests forwarded from MQTT are marked
+	origin := r.Header.Get(response.SourceHeader)
+	if origin == "" {
+		origin = OriginREST
+	}
+
 	// Do the dirty work elsewhere
-	Set(componentName, settingName, settingValue)
+	if err := SetFrom(componentName, settingName, settingValue, origin); err != nil {
+		response.WriteNew(&w, r, response.JSONResponse{Output: err.Error(), OK: false})
+		return
+	}
 
 	// Respond with OK
 	response := response.JSONResponse{Output: componentName, OK: true}
 	response.Write(&w, r)
 }
 
-// Set will handle actually updates or posts a new setting value
-func Set(componentName string, settingName string, settingValue string) bool {
+// Set will handle actually updates or posts a new setting value.
+// Returns an error if the value doesn't match the setting's schema, or a hook rejected it
+func Set(componentName string, settingName string, settingValue string) error {
+	return SetFrom(componentName, settingName, settingValue, OriginInternal)
+}
+
+// SetFrom is Set, recording where the change came from in the settings history
+func SetFrom(componentName string, settingName string, settingValue string, origin string) error {
 	// Format names
 	componentName = format.Name(componentName)
 	settingName = format.Name(settingName)
-	settingValue = format.Name(settingValue)
+
+	// Switching profiles changes every setting they hold at once
+	if componentName == "MDROID" && settingName == profileSetting {
+		return ActivateProfile(settingValue, origin)
+	}
+
+	// Validate the value, keeping its case only if declared case sensitive
+	settingValue, err := normalize(componentName, settingName, settingValue)
+	if err != nil {
+		return fmt.Errorf("Invalid value for setting %s[%s]: %s", componentName, settingName, err.Error())
+	}
+
+	// Settings held by the active profile can't change underneath it
+	Settings.mutex.RLock()
+	err = held(componentName, settingName)
+	Settings.mutex.RUnlock()
+	if err != nil {
+		return err
+	}
+
+	// Trigger sync hooks, any of them can stop the setting from changing
+	if err := runSyncHooks(componentName, settingName, settingValue); err != nil {
+		log.Error().Msg(err.Error())
+		return err
+	}
 
 	// Insert componentName into Map if not exists
 	Settings.mutex.Lock()
+	if err := held(componentName, settingName); err != nil {
+		Settings.mutex.Unlock()
+		return err
+	}
 	if _, ok := Settings.Data[componentName]; !ok {
 		Settings.Data[componentName] = make(map[string]string, 0)
 	}
 
 	// Update setting in inner map
+	oldValue, existed := Settings.Data[componentName][settingName]
 	Settings.Data[componentName][settingName] = settingValue
+	Settings.updated[componentName+"/"+settingName] = time.Now()
 	Settings.mutex.Unlock()
 
+	startHooks(componentName, settingName, settingValue)
+	publish(componentName, settingName, settingValue)
+	record(Change{Component: componentName, Name: settingName, OldValue: oldValue, NewValue: settingValue, Created: !existed, Origin: origin})
+
+	// Write out all settings to a file, along with any other sets close by
+	scheduleWrite()
+
+	return nil
+}
+
+// HandleDelete is the http wrapper for deleting a setting
+func HandleDelete(w http.ResponseWriter, r *http.Request) {
+	params := mux.Vars(r)
+	componentName := format.Name(params["component"])
+	settingName := format.Name(params["name"])
+
+	log.Debug().Msgf("Responding to DELETE request for setting %s on component %s", settingName, componentName)
+
+	origin := r.Header.Get(response.SourceHeader)
+	if origin == "" {
+		origin = OriginREST
+	}
+
+	if err := DeleteFrom(componentName, settingName, origin); err != nil {
+		response.WriteNew(&w, r, response.JSONResponse{Output: err.Error(), OK: false})
+		return
+	}
+	response.WriteNew(&w, r, response.JSONResponse{Output: componentName, OK: true})
+}
+
+// HandleDeleteComponent is the http wrapper for deleting a whole component
+func HandleDeleteComponent(w http.ResponseWriter, r *http.Request) {
+	params := mux.Vars(r)
+	componentName := format.Name(params["component"])
+
+	log.Debug().Msgf("Responding to DELETE request for setting component %s", componentName)
+
+	origin := r.Header.Get(response.SourceHeader)
+	if origin == "" {
+		origin = OriginREST
+	}
+
+	if err := DeleteComponentFrom(componentName, origin); err != nil {
+		response.WriteNew(&w, r, response.JSONResponse{Output: err.Error(), OK: false})
+		return
+	}
+	response.WriteNew(&w, r, response.JSONResponse{Output: componentName, OK: true})
+}
+
+// Delete removes a setting, running delete hooks and clearing it from MQTT.
+// Returns an error if the setting can't be deleted, or a hook rejected it
+func Delete(componentName string, settingName string) error {
+	return DeleteFrom(componentName, settingName, OriginInternal)
+}
+
+// DeleteFrom is Delete, recording where the change came from in the settings history
+func DeleteFrom(componentName string, settingName string, origin string) error {
+	componentName = format.Name(componentName)
+	settingName = format.Name(settingName)
+
+	Settings.mutex.Lock()
+	oldValue, ok := Settings.Data[componentName][settingName]
+	if !ok {
+		Settings.mutex.Unlock()
+		return fmt.Errorf("Could not find setting %s[%s]", componentName, settingName)
+	}
+	err := deletable(componentName, settingName)
+	Settings.mutex.Unlock()
+	if err != nil {
+		return err
+	}
+
+	// Trigger sync delete hooks, any of them can stop the setting from being deleted
+	if err := runSyncDeleteHooks(componentName, settingName); err != nil {
+		log.Error().Msg(err.Error())
+		return err
+	}
+
+	Settings.mutex.Lock()
+	oldValue, ok = Settings.Data[componentName][settingName]
+	if !ok {
+		Settings.mutex.Unlock()
+		return fmt.Errorf("Could not find setting %s[%s]", componentName, settingName)
+	}
+	remove(componentName, settingName)
+	Settings.mutex.Unlock()
+
+	startDeleteHooks(componentName, settingName)
+	unpublish(componentName, settingName)
+	record(Change{Component: componentName, Name: settingName, OldValue: oldValue, Removed: true, Origin: origin})
+	scheduleWrite()
+	return nil
+}
+
+// DeleteComponent removes every setting of a component, running delete hooks for each
+func DeleteComponent(componentName string) error {
+	return DeleteComponentFrom(componentName, OriginInternal)
+}
+
+// DeleteComponentFrom is DeleteComponent, recording where the changes came from in the settings history.
+// Nothing is deleted if any of the settings can't be
+func DeleteComponentFrom(componentName string, origin string) error {
+	componentName = format.Name(componentName)
+
+	Settings.mutex.Lock()
+	component, ok := Settings.Data[componentName]
+	if !ok {
+		Settings.mutex.Unlock()
+		return fmt.Errorf("Could not find component with name %s", componentName)
+	}
+	var settingNames []string
+	for settingName := range component {
+		if err := deletable(componentName, settingName); err != nil {
+			Settings.mutex.Unlock()
+			return err
+		}
+		settingNames = append(settingNames, settingName)
+	}
+	Settings.mutex.Unlock()
+	sort.Strings(settingNames)
+
+	// Trigger sync delete hooks for every setting, any of them can stop the component from being deleted
+	for _, settingName := range settingNames {
+		if err := runSyncDeleteHooks(componentName, settingName); err != nil {
+			log.Error().Msg(err.Error())
+			return err
+		}
+	}
+
+	var changes []Change
+	Settings.mutex.Lock()
+	for _, settingName := range settingNames {
+		if oldValue, ok := Settings.Data[componentName][settingName]; ok {
+			changes = append(changes, Change{Component: componentName, Name: settingName, OldValue: oldValue, Removed: true, Origin: origin})
+			remove(componentName, settingName)
+		}
+	}
+	Settings.mutex.Unlock()
+
+	for _, change := range changes {
+		startDeleteHooks(change.Component, change.Name)
+		unpublish(change.Component, change.Name)
+		record(change)
+	}
+	scheduleWrite()
+	return nil
+}
+
+// deletable returns why a setting can't be deleted, if it can't. Settings must be locked
+func deletable(componentName string, settingName string) error {
+	if err := held(componentName, settingName); err != nil {
+		return err
+	}
+
+	// It would only come back on the next load
+	if schema, ok := getSchema(componentName, settingName); ok && schema.Default != "" {
+		return fmt.Errorf("Setting %s[%s] has a default of %s, set it instead", componentName, settingName, schema.Default)
+	}
+	return nil
+}
+
+// remove deletes a setting, and its component once empty. Settings must be locked
+func remove(componentName string, settingName string) {
+	delete(Settings.Data[componentName], settingName)
+	if len(Settings.Data[componentName]) == 0 {
+		delete(Settings.Data, componentName)
+	}
+	delete(Settings.updated, componentName+"/"+settingName)
+}
+
+// LastUpdated returns when a setting was last changed while running, or "" if it hasn't been
+func LastUpdated(componentName string, settingName string) string {
+	Settings.mutex.RLock()
+	updated, ok := Settings.updated[componentName+"/"+settingName]
+	Settings.mutex.RUnlock()
+
+	if !ok {
+		return ""
+	}
+	return updated.Format("2006-01-02 15:04:05.999")
+}
+
+// publish sends an updated setting to MQTT and the event stream
+func publish(componentName string, settingName string, settingValue string) {
 	// Post to MQTT
 	if mqtt.IsConnected() {
 		topic := fmt.Sprintf("settings/%s/%s", componentName, settingName)
 		go mqtt.Publish(topic, settingValue)
 	}
 
+	// Send to event stream
+	events.Publish("setting", fmt.Sprintf("%s/%s", componentName, settingName), Setting{Component: componentName, Name: settingName, Value: settingValue, LastUpdated: LastUpdated(componentName, settingName)})
+
 	// Log our success
 	log.Info().Msgf("Updated setting of %s[%s] to %s", componentName, settingName, settingValue)
+}
 
-	// Write out all settings to a file
-	writeFile(Settings.File)
+// unpublish clears a deleted setting's retained MQTT message, and sends the deletion to the event stream
+func unpublish(componentName string, settingName string) {
+	if mqtt.IsConnected() {
+		go mqtt.Clear(fmt.Sprintf("settings/%s/%s", componentName, settingName))
+	}
 
-	// Trigger hooks
-	runHooks(componentName, settingName, settingValue)
+	events.Publish("setting_deleted", fmt.Sprintf("%s/%s", componentName, settingName), Setting{Component: componentName, Name: settingName})
 
-	return true
+	log.Info().Msgf("Deleted setting %s[%s]", componentName, settingName)
 }
@@ -0,0 +1,132 @@
+package settings
+
+import (
+	"fmt"
+	"sort"
+	"testing"
+)
+
+func TestSyncHooks(t *testing.T) {
+	useFile("")
+	defer Flush()
+	RegisterHookWithOptions("SYNC_TEST", HookOptions{Sync: true}, func(settingName string, settingValue string) error {
+		if settingValue == "REJECTED" {
+			return fmt.Errorf("%s is rejected", settingValue)
+		}
+		return nil
+	})
+
+	tables := []struct {
+		setting string
+		value   string
+		err     bool
+		want    string // Empty if the setting shouldn't exist
+	}{
+		{"NEW", "REJECTED", true, ""},
+		{"EXISTING", "ACCEPTED", false, "ACCEPTED"},
+		{"EXISTING", "REJECTED", true, "ACCEPTED"},
+	}
+
+	for _, table := range tables {
+		if err := Set("SYNC_TEST", table.setting, table.value); (err != nil) != table.err {
+			t.Errorf("Set(SYNC_TEST, %s, %s) returned %v; want error %v", table.setting, table.value, err, table.err)
+		}
+		value, err := Get("SYNC_TEST", table.setting)
+		if table.want == "" && err == nil {
+			t.Errorf("SYNC_TEST[%s] = %s; want it not to exist", table.setting, value)
+		} else if table.want != "" && value != table.want {
+			t.Errorf("SYNC_TEST[%s] = %s; want %s", table.setting, value, table.want)
+		}
+	}
+}
+
+func TestDelete(t *testing.T) {
+	useFile("")
+	defer Flush()
+	RegisterSchema("DELETE_TEST", map[string]Schema{"LEVEL": {Type: TypeInt, Default: "1"}})
+	Settings.mutex.Lock()
+	Settings.Data["DELETE_TEST"] = map[string]string{"ADDRESS": "10.0.0.2", "NAME": "BOARD", "LEVEL": "1"}
+	Settings.Data["DELETE_TEST_BOARD"] = map[string]string{"ADDRESS": "10.0.0.3", "PORT": "22"}
+	Settings.mutex.Unlock()
+
+	var set, deleted []string
+	RegisterHookWithOptions("DELETE_TEST", HookOptions{Sync: true}, func(settingName string, settingValue string) error {
+		set = append(set, settingName)
+		return nil
+	})
+	for _, componentName := range []string{"DELETE_TEST", "DELETE_TEST_BOARD"} {
+		componentName := componentName
+		RegisterDeleteHook(componentName, HookOptions{Sync: true}, func(settingName string) error {
+			deleted = append(deleted, componentName+"/"+settingName)
+			return nil
+		})
+	}
+
+	tables := []struct {
+		component string
+		setting   string // Empty deletes the whole component
+		err       bool
+		deleted   []string
+	}{
+		{"delete_test", "address", false, []string{"DELETE_TEST/ADDRESS"}},
+		{"DELETE_TEST", "ADDRESS", true, nil},
+		{"DELETE_TEST", "LEVEL", true, nil},
+		{"DELETE_TEST", "", true, nil},
+		{"DELETE_TEST_BOARD", "", false, []string{"DELETE_TEST_BOARD/ADDRESS", "DELETE_TEST_BOARD/PORT"}},
+		{"DELETE_TEST_BOARD", "", true, nil},
+	}
+
+	for _, table := range tables {
+		deleted = nil
+		var err error
+		if table.setting == "" {
+			err = DeleteComponent(table.component)
+		} else {
+			err = Delete(table.component, table.setting)
+		}
+		if (err != nil) != table.err {
+			t.Errorf("Deleting %s[%s] returned %v; want error %v", table.component, table.setting, err, table.err)
+		}
+		sort.Strings(deleted)
+		if len(deleted) != len(table.deleted) {
+			t.Errorf("Deleting %s[%s] ran delete hooks for %v; want %v", table.component, table.setting, deleted, table.deleted)
+		} else {
+			for i := range deleted {
+				if deleted[i] != table.deleted[i] {
+					t.Errorf("Deleting %s[%s] ran delete hooks for %v; want %v", table.component, table.setting, deleted, table.deleted)
+					break
+				}
+			}
+		}
+	}
+
+	if len(set) != 0 {
+		t.Errorf("Deleting ran set hooks for %v; want none", set)
+	}
+	if _, err := GetComponent("DELETE_TEST_BOARD"); err == nil {
+		t.Errorf("DELETE_TEST_BOARD still exists after being deleted")
+	}
+	if value, err := Get("DELETE_TEST", "NAME"); err != nil || value != "BOARD" {
+		t.Errorf("Get(DELETE_TEST, NAME) = %s, %v; want BOARD left alone", value, err)
+	}
+
+	// A sync delete hook can keep a setting, or its whole component
+	Settings.mutex.Lock()
+	Settings.Data["DELETE_TEST_KEPT"] = map[string]string{"ADDRESS": "10.0.0.4", "NAME": "KEPT"}
+	Settings.mutex.Unlock()
+	RegisterDeleteHook("DELETE_TEST_KEPT", HookOptions{Sync: true}, func(settingName string) error {
+		if settingName == "NAME" {
+			return fmt.Errorf("%s can't be deleted", settingName)
+		}
+		return nil
+	})
+	if err := Delete("DELETE_TEST_KEPT", "NAME"); err == nil {
+		t.Errorf("Deleting DELETE_TEST_KEPT[NAME] should have been rejected")
+	}
+	if err := DeleteComponent("DELETE_TEST_KEPT"); err == nil {
+		t.Errorf("Deleting DELETE_TEST_KEPT should have been rejected")
+	}
+	if component, err := GetComponent("DELETE_TEST_KEPT"); err != nil || len(component) != 2 {
+		t.Errorf("GetComponent(DELETE_TEST_KEPT) = %v, %v; want both settings kept", component, err)
+	}
+}
@@ -0,0 +1,190 @@
+package settings
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/rs/zerolog/log"
+)
+
+// reloadDelay waits for an edit to the settings file to finish before reading it
+const reloadDelay = 500 * time.Millisecond
+
+// lastWrite is what MDroid last wrote to (or read from) the settings file, so our own writes aren't reloaded
+var lastWrite struct {
+	contents []byte
+	mutex    sync.Mutex
+}
+
+// Watch reloads the settings file whenever it's changed outside of MDroid, i.e. edited by hand
+func Watch() error {
+	file := settingsFile()
+	if file == "" {
+		return fmt.Errorf("Empty filename")
+	}
+
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+
+	// Editors (and our own writes) replace the file rather than write to it, so watch its directory
+	if err = watcher.Add(filepath.Dir(file)); err != nil {
+		watcher.Close()
+		return err
+	}
+
+	go func() {
+		var timer *time.Timer
+		for {
+			select {
+			case event, ok := <-watcher.Events:
+				if !ok {
+					return
+				}
+				if filepath.Clean(event.Name) != filepath.Clean(file) || event.Op&(fsnotify.Write|fsnotify.Create) == 0 {
+					continue
+				}
+				if timer != nil {
+					timer.Stop()
+				}
+				timer = time.AfterFunc(reloadDelay, func() {
+					if err := Reload(); err != nil {
+						log.Error().Msg(err.Error())
+					}
+				})
+			case err, ok := <-watcher.Errors:
+				if !ok {
+					return
+				}
+				log.Error().Msgf("Error watching settings file: %s", err.Error())
+			}
+		}
+	}()
+
+	log.Info().Msgf("Watching settings file '%s' for changes", file)
+	return nil
+}
+
+// setLastWrite records the settings file as MDroid last saw it
+func setLastWrite(contents []byte) {
+	lastWrite.mutex.Lock()
+	lastWrite.contents = contents
+	lastWrite.mutex.Unlock()
+}
+
+// Reload applies changes made to the settings file outside of MDroid, running hooks only for settings that changed.
+// Invalid files are rejected, leaving the running settings in place
+func Reload() error {
+	file := settingsFile()
+	contents, err := ioutil.ReadFile(file)
+	if err != nil {
+		return fmt.Errorf("Not reloading settings file '%s': %s", file, err.Error())
+	}
+
+	lastWrite.mutex.Lock()
+	unchanged := bytes.Equal(contents, lastWrite.contents)
+	lastWrite.mutex.Unlock()
+	if unchanged {
+		return nil
+	}
+
+	var newData map[string]map[string]string
+	if err = json.Unmarshal(contents, &newData); err != nil {
+		return fmt.Errorf("Rejecting settings file '%s', keeping running settings: %s", file, err.Error())
+	}
+	if len(newData) == 0 {
+		return fmt.Errorf("Rejecting settings file '%s', keeping running settings: it's empty", file)
+	}
+	if err = validate(newData); err != nil {
+		return fmt.Errorf("Rejecting settings file '%s', keeping running settings: %s", file, err.Error())
+	}
+	applyDefaults(newData)
+
+	// Sync hooks can reject the file, like any other set
+	Settings.mutex.RLock()
+	changes := diff(effective(Settings.Data), effective(newData), OriginFile)
+	Settings.mutex.RUnlock()
+	if err = checkChanges(changes); err != nil {
+		return fmt.Errorf("Rejecting settings file '%s', keeping running settings: %s", file, err.Error())
+	}
+
+	Settings.mutex.Lock()
+	changes = diff(effective(Settings.Data), effective(newData), OriginFile)
+	Settings.Data = newData
+	for _, change := range changes {
+		Settings.updated[change.Component+"/"+change.Name] = change.Time
+	}
+	Settings.mutex.Unlock()
+	setLastWrite(contents)
+
+	announce(changes)
+	log.Info().Msgf("Reloaded settings file '%s', %d settings changed", file, len(changes))
+	return nil
+}
+
+// checkChanges runs the sync hooks for changes about to be made outside of Set, returning the first rejection
+func checkChanges(changes []Change) error {
+	for _, change := range changes {
+		var err error
+		if change.Removed {
+			err = runSyncDeleteHooks(change.Component, change.Name)
+		} else {
+			err = runSyncHooks(change.Component, change.Name, change.NewValue)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// announce records changes made outside of Set, once checkChanges passed them, starting hooks and publishing each
+func announce(changes []Change) {
+	for _, change := range changes {
+		record(change)
+		if change.Removed {
+			startDeleteHooks(change.Component, change.Name)
+			unpublish(change.Component, change.Name)
+			continue
+		}
+		startHooks(change.Component, change.Name, change.NewValue)
+		publish(change.Component, change.Name, change.NewValue)
+	}
+}
+
+// diff lists every setting added, changed or removed between two sets of settings, in order
+func diff(oldData map[string]map[string]string, newData map[string]map[string]string, origin string) []Change {
+	var changes []Change
+	now := time.Now()
+	for componentName, component := range newData {
+		for settingName, value := range component {
+			oldValue, existed := oldData[componentName][settingName]
+			if !existed || oldValue != value {
+				changes = append(changes, Change{Time: now, Component: componentName, Name: settingName, OldValue: oldValue, NewValue: value, Created: !existed, Origin: origin})
+			}
+		}
+	}
+	for componentName, component := range oldData {
+		for settingName, oldValue := range component {
+			if _, ok := newData[componentName][settingName]; !ok {
+				changes = append(changes, Change{Time: now, Component: componentName, Name: settingName, OldValue: oldValue, Removed: true, Origin: origin})
+			}
+		}
+	}
+
+	sort.Slice(changes, func(i, j int) bool {
+		if changes[i].Component != changes[j].Component {
+			return changes[i].Component < changes[j].Component
+		}
+		return changes[i].Name < changes[j].Name
+	})
+	return changes
+}
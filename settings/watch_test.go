@@ -0,0 +1,74 @@
+package settings
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestReload(t *testing.T) {
+	dir, err := ioutil.TempDir("", "settings")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "settings.json")
+	useFile(file)
+	defer Flush()
+	Settings.mutex.Lock()
+	Settings.Data = map[string]map[string]string{}
+	Settings.mutex.Unlock()
+
+	var changed []string
+	RegisterHookWithOptions("RELOAD_TEST", HookOptions{Sync: true}, func(settingName string, settingValue string) error {
+		changed = append(changed, settingName+"="+settingValue)
+		if settingValue == "REJECTED" {
+			return fmt.Errorf("%s is rejected", settingValue)
+		}
+		return nil
+	})
+	RegisterSchema("RELOAD_TEST", map[string]Schema{"COUNT": {Type: TypeInt}})
+
+	tables := []struct {
+		contents string
+		err      bool
+		changed  []string
+		value    string
+	}{
+		{`{"RELOAD_TEST": {"COUNT": "1", "NAME": "A"}}`, false, []string{"COUNT=1", "NAME=A"}, "A"},
+		{`{"RELOAD_TEST": {"COUNT": "1", "NAME": "B"}}`, false, []string{"NAME=B"}, "B"},
+		{`{"RELOAD_TEST": {"COUNT": "one", "NAME": "C"}}`, true, nil, "B"},
+		{`{"RELOAD_TEST": {"COUNT": "1", "NAME": "D"`, true, nil, "B"},
+		{`{"RELOAD_TEST": {"COUNT": "2", "NAME": "REJECTED"}}`, true, []string{"COUNT=2", "NAME=REJECTED"}, "B"},
+		{`{"RELOAD_TEST": {"COUNT": "1", "NAME": "B"}}`, false, nil, "B"},
+	}
+
+	for _, table := range tables {
+		if err := ioutil.WriteFile(file, []byte(table.contents), 0644); err != nil {
+			t.Fatal(err)
+		}
+		changed = nil
+		err := Reload()
+		if (err != nil) != table.err {
+			t.Errorf("Reload of %s returned %v; want error %v", table.contents, err, table.err)
+		}
+		if len(changed) != len(table.changed) {
+			t.Errorf("Reload of %s ran hooks for %v; want %v", table.contents, changed, table.changed)
+		} else {
+			for i := range changed {
+				if changed[i] != table.changed[i] {
+					t.Errorf("Reload of %s ran hooks for %v; want %v", table.contents, changed, table.changed)
+					break
+				}
+			}
+		}
+		if value, _ := Get("RELOAD_TEST", "NAME"); value != table.value {
+			t.Errorf("Reload of %s left NAME = %s; want %s", table.contents, value, table.value)
+		}
+		if value, _ := Get("RELOAD_TEST", "COUNT"); value != "1" {
+			t.Errorf("Reload of %s left COUNT = %s; want 1", table.contents, value)
+		}
+	}
+}